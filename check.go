@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"markdown-server/markdown/ast"
+)
+
+/***********************************************
+*** FUNCTIONS FOR CHECKING LINKS AND ANCHORS ***
+************************************************/
+
+// PageLinks is everything the link check needs to know about a page
+type PageLinks struct {
+	Meta    PageMeta
+	Anchors []string
+	Links   []PageLink
+}
+
+// PageLink is a link or image destination as written in the markdown file
+type PageLink struct {
+	Destination string
+	// Line is the line in the markdown file, or 0 if the link comes from an included file
+	Line  int
+	Image bool
+}
+
+// LinkProblem is a single finding of the link check
+type LinkProblem struct {
+	File    string
+	Line    int
+	Target  string
+	Message string
+}
+
+func (problem LinkProblem) String() string {
+	if problem.Line == 0 {
+		return fmt.Sprintf("%s: %s '%s'", problem.File, problem.Message, problem.Target)
+	}
+	return fmt.Sprintf("%s:%d: %s '%s'", problem.File, problem.Line, problem.Message, problem.Target)
+}
+
+// LinkIndex holds the links and anchors of every page of the site by its path relative to the markdown folder
+type LinkIndex struct {
+	mu    sync.Mutex
+	pages map[string]*PageLinks
+}
+
+// SiteLinks is the link index of the current build, it is replaced on every full rebuild
+var SiteLinks = NewLinkIndex()
+
+func NewLinkIndex() *LinkIndex {
+	return &LinkIndex{pages: make(map[string]*PageLinks)}
+}
+
+func (index *LinkIndex) Set(relative string, links *PageLinks) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.pages[relative] = links
+}
+
+// Remove deletes the links of the page or directory
+func (index *LinkIndex) Remove(relative string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	RemoveInside(index.pages, relative)
+}
+
+// Check resolves every link of every page and returns the problems sorted by file and line
+func (index *LinkIndex) Check() []LinkProblem {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	problems := make([]LinkProblem, 0)
+	for _, relative := range SortedKeys(index.pages) {
+		for _, link := range index.pages[relative].Links {
+			if message := index.checkLink(relative, link); message != "" {
+				problems = append(problems, LinkProblem{
+					File:    SourcePath(relative),
+					Line:    link.Line,
+					Target:  link.Destination,
+					Message: message,
+				})
+			}
+		}
+	}
+	return problems
+}
+
+// checkLink returns why the link is broken or an empty string if it is fine
+func (index *LinkIndex) checkLink(relative string, link PageLink) string {
+	target := relative
+	fragment := ""
+	if strings.HasPrefix(link.Destination, "#") {
+		fragment = link.Destination[1:]
+	} else {
+		resolved, parsed, ok := ResolveLink(relative, link.Destination)
+		if !ok {
+			return ""
+		}
+		target, fragment = resolved, parsed.Fragment
+
+		info, err := os.Stat(SourcePath(target))
+		if err != nil {
+			source, generated := GeneratedOutputSource(target)
+			switch {
+			case !generated && link.Image:
+				return "image does not exist"
+			case !generated:
+				return "link to missing file"
+			case source == "":
+				return ""
+			}
+			target = source
+		} else if info.IsDir() || !strings.HasSuffix(target, ".md") {
+			return ""
+		}
+	}
+
+	page, ok := index.pages[target]
+	if !ok {
+		// the page failed to build, which is reported by the build itself
+		return ""
+	}
+	if page.Meta.Draft && !BuildDrafts && target != relative {
+		return "link to draft page"
+	}
+	if fragment == "" || strings.HasPrefix(fragment, "fn:") || strings.HasPrefix(fragment, "fnref:") {
+		return ""
+	}
+	if !slices.Contains(page.Anchors, fragment) {
+		return "link to missing anchor"
+	}
+	return ""
+}
+
+// GeneratedOutputSource reports if a link target relative to the markdown folder is a generated file,
+// like the page of a markdown file under its html or pretty URL. The markdown file is returned for pages,
+// files without one, like the search page and the directory indexes, return an empty source.
+func GeneratedOutputSource(target string) (string, bool) {
+	output := target
+	if filepath.Ext(target) == "" {
+		output = filepath.Join(target, IndexPage)
+	}
+	if source, ok := SourceForOutput(output); ok {
+		return source, true
+	}
+	if target == "search" || target == SearchPage || target == SearchIndexFile || GeneratedStylesheet(target) != nil {
+		return "", true
+	}
+	if filepath.Base(output) == IndexPage {
+		directory := SiteNavigation.Tree().Find(filepath.Dir(output))
+		return "", directory != nil && NeedsDirectoryIndex(directory, filepath.Dir(output))
+	}
+	return "", false
+}
+
+// CollectPageLinks records the anchors of all headings and the original destination of all links.
+// It has to run before the links are rewritten.
+func CollectPageLinks(meta PageMeta, doc ast.Node, markdownText []byte) *PageLinks {
+	links := &PageLinks{Meta: meta}
+	lines := &LineLocator{text: markdownText}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Heading:
+			if n.HeadingID != "" {
+				links.Anchors = append(links.Anchors, n.HeadingID)
+			}
+		case *ast.Link:
+			if n.NoteID == 0 && n.Footnote == nil && len(n.Destination) != 0 {
+				links.Links = append(links.Links, PageLink{Destination: string(n.Destination), Line: lines.Locate(n.Destination)})
+			}
+		case *ast.Image:
+			links.Links = append(links.Links, PageLink{Destination: string(n.Destination), Line: lines.Locate(n.Destination), Image: true})
+		}
+		return ast.GoToNext
+	})
+	return links
+}
+
+// LineLocator finds the lines of link destinations, which are visited in the order they are written
+type LineLocator struct {
+	text   []byte
+	offset int
+}
+
+func (locator *LineLocator) Locate(destination []byte) int {
+	pos := bytes.Index(locator.text[locator.offset:], destination)
+	if pos == -1 {
+		// written as a reference or in an included file, search the whole text once more
+		pos = bytes.Index(locator.text, destination)
+		if pos == -1 {
+			return 0
+		}
+		return bytes.Count(locator.text[:pos], []byte("\n")) + 1
+	}
+	locator.offset += pos + len(destination)
+	return bytes.Count(locator.text[:locator.offset-len(destination)], []byte("\n")) + 1
+}
+
+// LogLinkProblems prints the result of the link check of the current build
+func LogLinkProblems() {
+	problems := SiteLinks.Check()
+	for _, problem := range problems {
+		log.Println(problem)
+	}
+	if len(problems) != 0 {
+		log.Printf("Link check found %d problems", len(problems))
+	}
+}
+
+// RunCheck parses every markdown file without writing anything and returns the exit code
+// for the check mode: 0 if all front matter, includes, links and anchors are fine, 1 otherwise.
+func RunCheck() int {
+	SiteLinks = NewLinkIndex()
+	SiteWarnings = NewWarningIndex()
+	SiteNavigation.Refresh(".")
+	failed := 0
+	err := filepath.Walk(FullPath, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if IsLayoutFolder(path, info) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		relative, err := SourceRelative(path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(SourcePath(relative))
+		if err != nil {
+			return err
+		}
+		page, err := GenerateHTMLFromMarkdown(SourcePath(relative), data)
+		SiteWarnings.Set(relative, page.Warnings)
+		if err != nil {
+			failed++
+			fmt.Printf("%s: %v\n", SourcePath(relative), err)
+			return nil
+		}
+		SiteLinks.Set(relative, page.Links)
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("While checking markdown files encountered error: %v\n", err)
+		return 1
+	}
+
+	warnings := SiteWarnings.All()
+	for _, warning := range warnings {
+		fmt.Println(warning)
+	}
+	problems := SiteLinks.Check()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	// warnings do not fail the check, the pages are built anyway
+	if len(warnings) != 0 {
+		fmt.Printf("Check found %d warnings\n", len(warnings))
+	}
+	if failed+len(problems) != 0 {
+		fmt.Printf("Check failed: %d broken pages, %d link problems\n", failed, len(problems))
+		return 1
+	}
+	fmt.Println("Check passed")
+	return 0
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+/*******************************************
+*** FUNCTIONS FOR THE COMMAND LINE ***
+********************************************/
+
+const Usage = `Usage: markdown-server [command] [flags]
+
+Commands:
+  serve   build the target folder and serve it (default)
+  build   build the target folder and exit
+  check   validate front matter, includes, links and anchors without writing anything
+  clean   remove the target folder
+
+Run "markdown-server <command> -h" for the flags of a command. Flags override
+the environment variables named in their description, which override the
+config file in the markdown folder.
+`
+
+// Command is a subcommand of the command line, it returns the exit code
+type Command struct {
+	Name  string
+	Flags func(flags *flag.FlagSet, options *Options)
+	Run   func(options *Options) int
+}
+
+// Options are the values of all flags, only the ones given on the command line are applied
+type Options struct {
+	Source     string
+	Target     string
+	PrettyURLs bool
+	Drafts     bool
+	Workers    int
+	TOC        bool
+	TOCLevels  LevelRange
+	Address    string
+	Watch      bool
+	OnDemand   bool
+	DryRun     bool
+	Diagrams   map[string]string
+
+	// set contains the names of the flags given on the command line
+	set map[string]bool
+}
+
+var Commands = []Command{
+	{Name: "serve", Flags: ServeFlags, Run: RunServe},
+	{Name: "build", Flags: BuildFlags, Run: RunBuild},
+	{Name: "check", Flags: CommonFlags, Run: RunCheckCommand},
+	{Name: "clean", Flags: CleanFlags, Run: RunClean},
+}
+
+// RunCommandLine runs the command named by the first argument, or serve if there is none
+func RunCommandLine(args []string) int {
+	name := "serve"
+	if len(args) != 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+		fmt.Print(Usage)
+		return 0
+	}
+	if len(args) != 0 && args[0] != "" && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+
+	for _, command := range Commands {
+		if command.Name != name {
+			continue
+		}
+		options := &Options{set: make(map[string]bool)}
+		flags := flag.NewFlagSet(name, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		command.Flags(flags, options)
+		err := flags.Parse(args)
+		if err == nil && flags.NArg() != 0 {
+			err = fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		}
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Printf("Flags of %s:\n", name)
+			flags.SetOutput(os.Stdout)
+			flags.PrintDefaults()
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "markdown-server %s: %v\n\nFlags of %s:\n", name, err, name)
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+			return 2
+		}
+		flags.Visit(func(f *flag.Flag) {
+			options.set[f.Name] = true
+		})
+		return command.Run(options)
+	}
+	fmt.Fprintf(os.Stderr, "markdown-server: unknown command %q\n\n%s", name, Usage)
+	return 2
+}
+
+func CommonFlags(flags *flag.FlagSet, options *Options) {
+	flags.StringVar(&options.Source, "source", "", "markdown folder (MARKDOWN_PATH)")
+	flags.StringVar(&options.Target, "target", "", "target folder for the generated files (HTML_TARGET_PATH)")
+	flags.BoolVar(&options.PrettyURLs, "pretty-urls", false, "write page.md to page/index.html (PRETTY_URLS)")
+	flags.BoolVar(&options.Drafts, "drafts", false, "build pages marked as draft (BUILD_DRAFTS)")
+	flags.IntVar(&options.Workers, "workers", 0, "number of files built at the same time (BUILD_WORKERS)")
+	flags.BoolVar(&options.TOC, "toc", false, "place a table of contents in every page, unless the config or front matter turn it off (TOC)")
+	flags.Func("toc-levels", "heading levels of the table of contents like 2-4, unless the config or front matter change them (TOC_LEVELS)", func(text string) error {
+		levels, err := ParseLevelRange(text)
+		if err == nil && levels.IsZero() {
+			err = errors.New("no heading levels given")
+		}
+		options.TOCLevels = levels
+		return err
+	})
+	flags.Func("diagram-command", "command converting diagrams of a language to SVG like \"dot=dot -Tsvg\", can be repeated (DIAGRAM_COMMANDS)", func(entry string) error {
+		language, command, err := ParseDiagramCommand(entry)
+		if err != nil {
+			return err
+		}
+		if options.Diagrams == nil {
+			options.Diagrams = make(map[string]string)
+		}
+		options.Diagrams[language] = command
+		return nil
+	})
+}
+
+func BuildFlags(flags *flag.FlagSet, options *Options) {
+	CommonFlags(flags, options)
+	flags.BoolVar(&options.DryRun, "dry-run", false, "list the files that would be written instead of writing them")
+}
+
+func CleanFlags(flags *flag.FlagSet, options *Options) {
+	flags.StringVar(&options.Source, "source", "", "markdown folder (MARKDOWN_PATH)")
+	flags.StringVar(&options.Target, "target", "", "target folder to remove (HTML_TARGET_PATH)")
+	flags.BoolVar(&options.DryRun, "dry-run", false, "list the folder that would be removed instead of removing it")
+}
+
+func ServeFlags(flags *flag.FlagSet, options *Options) {
+	CommonFlags(flags, options)
+	flags.StringVar(&options.Address, "address", "", "address to listen on (ADDRESS)")
+	flags.BoolVar(&options.Watch, "watch", false, "rebuild changed files and reload the browser (HOT_RELOAD)")
+	flags.BoolVar(&options.OnDemand, "on-demand", false, "render markdown files on request instead of building the target folder (ON_DEMAND)")
+}
+
+// Apply loads the config file and sets the globals of the given flags, which take precedence over both
+// the environment and the config file. The error of a broken config file is returned after the flags are
+// applied, the defaults are used in its place.
+func (options *Options) Apply() error {
+	if options.set["source"] {
+		FullPath = options.Source
+	}
+	// the table of contents settings are defaults of the page settings, so they are set before the config
+	// file is loaded and the config file and front matter can still change them
+	if options.set["toc"] {
+		DefaultTOC = options.TOC
+	}
+	if options.set["toc-levels"] {
+		DefaultTOCLevels = options.TOCLevels
+	}
+	configErr := PopulateVariables()
+
+	if options.set["target"] {
+		TargetFolder = options.Target
+		OnDemand = TargetFolder == ""
+	}
+	if options.set["pretty-urls"] {
+		PrettyURLs = options.PrettyURLs
+	}
+	if options.set["drafts"] {
+		BuildDrafts = options.Drafts
+	}
+	if options.set["workers"] {
+		BuildWorkers = max(1, options.Workers)
+	}
+	maps.Copy(DiagramCommands, options.Diagrams)
+	if options.set["address"] {
+		Address = options.Address
+	}
+	if options.set["watch"] {
+		HotReload = options.Watch
+	}
+	if options.set["on-demand"] {
+		OnDemand = options.OnDemand || TargetFolder == ""
+	}
+	DryRun = options.DryRun
+	return configErr
+}
+
+func RunServe(options *Options) int {
+	configErr := options.Apply()
+	// a watching server keeps running with broken files and shows their errors in the browser until they are fixed
+	if configErr != nil && !HotReload {
+		fmt.Fprintf(os.Stderr, "markdown-server serve: %v\n", configErr)
+		return 1
+	}
+	if !OnDemand {
+		CleanUpFolders()
+		if !WalkFileTreeTwice() && !HotReload {
+			fmt.Fprintln(os.Stderr, "markdown-server serve: the build failed")
+			return 1
+		}
+	}
+	if configErr != nil {
+		log.Printf("While loading the config file encountered error: %v", configErr)
+		SetConfigError(configErr)
+	}
+	StartServingGeneratedFiles()
+	return 0
+}
+
+func RunBuild(options *Options) int {
+	if err := options.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "markdown-server build: %v\n", err)
+		return 1
+	}
+	if TargetFolder == "" {
+		fmt.Fprintln(os.Stderr, "markdown-server build: no target folder, set --target, HTML_TARGET_PATH or output.target")
+		return 2
+	}
+	CleanUpFolders()
+	built := WalkFileTreeTwice()
+	PrintDryRun()
+	if !built {
+		fmt.Fprintln(os.Stderr, "markdown-server build: the build failed")
+		return 1
+	}
+	return 0
+}
+
+func RunCheckCommand(options *Options) int {
+	if err := options.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "markdown-server check: %v\n", err)
+		return 1
+	}
+	return RunCheck()
+}
+
+func RunClean(options *Options) int {
+	if err := options.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "markdown-server clean: %v\n", err)
+		return 1
+	}
+	if TargetFolder == "" {
+		fmt.Fprintln(os.Stderr, "markdown-server clean: no target folder, set --target, HTML_TARGET_PATH or output.target")
+		return 2
+	}
+	if err := RemoveTarget(TargetFolder); err != nil {
+		fmt.Fprintf(os.Stderr, "While deleting '%s' encountered error: %v\n", TargetFolder, err)
+		return 1
+	}
+	PrintDryRun()
+	return 0
+}
+
+/*********************************************
+*** FUNCTIONS FOR WRITING THE TARGET FOLDER ***
+**********************************************/
+
+// DryRun lists the changes to the target folder instead of making them
+var DryRun = false
+
+var dryRun struct {
+	mu      sync.Mutex
+	changes map[string]string
+}
+
+func recordDryRun(path string, change string) {
+	dryRun.mu.Lock()
+	defer dryRun.mu.Unlock()
+	if dryRun.changes == nil {
+		dryRun.changes = make(map[string]string)
+	}
+	dryRun.changes[path] = change
+}
+
+// WriteTarget writes a file of the target folder and creates its folder if necessary
+func WriteTarget(path string, data []byte) error {
+	if DryRun {
+		recordDryRun(path, "write "+strconv.Itoa(len(data))+" bytes")
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func MakeTargetFolder(path string) error {
+	if DryRun {
+		return nil
+	}
+	return os.MkdirAll(path, 0700)
+}
+
+// RemoveTarget removes a file or folder of the target folder, a missing one is not an error
+func RemoveTarget(path string) error {
+	if DryRun {
+		if _, err := os.Stat(path); err == nil {
+			recordDryRun(path, "remove")
+		}
+		return nil
+	}
+	return os.RemoveAll(path)
+}
+
+// RemoveEmptyTarget removes a folder of the target folder if nothing is left inside of it
+func RemoveEmptyTarget(path string) {
+	if DryRun {
+		return
+	}
+	_ = os.Remove(path)
+}
+
+// PrintDryRun lists the recorded changes sorted by path
+func PrintDryRun() {
+	if !DryRun {
+		return
+	}
+	dryRun.mu.Lock()
+	defer dryRun.mu.Unlock()
+	paths := SortedKeys(dryRun.changes)
+	for _, path := range paths {
+		fmt.Printf("%s: %s\n", path, dryRun.changes[path])
+	}
+	log.Printf("Dry run: %d changes to the target folder", len(paths))
+}
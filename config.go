@@ -0,0 +1,979 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"markdown-server/chroma"
+	format "markdown-server/chroma/formatters/html"
+	"markdown-server/chroma/styles"
+	"markdown-server/markdown/html"
+	"markdown-server/markdown/parser"
+	"markdown-server/reload"
+)
+
+/**********************************************
+*** FUNCTIONS FOR LOADING THE CONFIG FILE ***
+***********************************************/
+
+// ConfigNames are the config files looked up in the root of the markdown folder, the first one found is used.
+// Environment variables take precedence over the config file.
+var ConfigNames = []string{"markdown-server.toml", "markdown-server.yaml", "markdown-server.yml", "markdown-server.json"}
+
+// Config is the content of the config file, every setting missing from it keeps its default
+type Config struct {
+	// File is the absolute path of the config file, or empty if there is none
+	File string
+	// Page holds the settings for pages outside of every directory override
+	Page PageConfig
+	// Directories maps directories relative to the markdown folder to the settings of their pages,
+	// each one already merged with the settings of the directories containing it
+	Directories map[string]PageConfig
+	Output      OutputConfig
+	Server      ServerConfig
+}
+
+// PageConfig holds the settings that can differ per directory
+type PageConfig struct {
+	Extensions    parser.Extensions
+	RendererFlags html.Flags
+	Highlight     HighlightConfig
+	TOC           bool
+	TOCLevels     LevelRange
+	Diagrams      DiagramConfig
+	Math          MathConfig
+
+	// the styles and formatter are created from Highlight once the config is decoded
+	style     *chroma.Style
+	darkStyle *chroma.Style
+	formatter *format.Formatter
+}
+
+type HighlightConfig struct {
+	Style string
+	// DarkStyle is used instead of Style when the browser prefers a dark color scheme
+	DarkStyle    string
+	LineNumbers  bool
+	TabWidth     int
+	InlineStyles bool
+	// DetectLanguage guesses the language of code blocks without a known language
+	DetectLanguage bool
+}
+
+// OutputConfig holds the settings of the build, they only take effect on the next full build
+type OutputConfig struct {
+	Target     string
+	PrettyURLs bool
+	Drafts     bool
+	Workers    int
+}
+
+// ServerConfig holds the settings of the server, they only take effect on a restart
+type ServerConfig struct {
+	Address   string
+	HotReload bool
+	OnDemand  bool
+	// ReloadTransport is how browsers listen for reloads: auto, websocket or sse
+	ReloadTransport string
+	// the timeouts of the http server, zero disables them
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is how long open requests may finish after SIGINT or SIGTERM
+	ShutdownTimeout time.Duration
+}
+
+var ExtensionNames = map[string]parser.Extensions{
+	"no-intra-emphasis":          parser.NoIntraEmphasis,
+	"tables":                     parser.Tables,
+	"fenced-code":                parser.FencedCode,
+	"autolink":                   parser.Autolink,
+	"strikethrough":              parser.Strikethrough,
+	"lax-html-blocks":            parser.LaxHTMLBlocks,
+	"space-headings":             parser.SpaceHeadings,
+	"hard-line-break":            parser.HardLineBreak,
+	"non-blocking-space":         parser.NonBlockingSpace,
+	"tab-size-eight":             parser.TabSizeEight,
+	"footnotes":                  parser.Footnotes,
+	"no-empty-line-before-block": parser.NoEmptyLineBeforeBlock,
+	"heading-ids":                parser.HeadingIDs,
+	"titleblock":                 parser.Titleblock,
+	"backslash-line-break":       parser.BackslashLineBreak,
+	"definition-lists":           parser.DefinitionLists,
+	"mathjax":                    parser.MathJax,
+	"ordered-list-start":         parser.OrderedListStart,
+	"attributes":                 parser.Attributes,
+	"super-subscript":            parser.SuperSubscript,
+	"empty-lines-break-list":     parser.EmptyLinesBreakList,
+	"includes":                   parser.Includes,
+	"mmark":                      parser.Mmark,
+}
+
+var RendererFlagNames = map[string]html.Flags{
+	"skip-html":                 html.SkipHTML,
+	"skip-images":               html.SkipImages,
+	"skip-links":                html.SkipLinks,
+	"safelink":                  html.Safelink,
+	"nofollow-links":            html.NofollowLinks,
+	"noreferrer-links":          html.NoreferrerLinks,
+	"noopener-links":            html.NoopenerLinks,
+	"href-target-blank":         html.HrefTargetBlank,
+	"use-xhtml":                 html.UseXHTML,
+	"footnote-return-links":     html.FootnoteReturnLinks,
+	"footnote-no-hr-tag":        html.FootnoteNoHRTag,
+	"smartypants":               html.Smartypants,
+	"smartypants-fractions":     html.SmartypantsFractions,
+	"smartypants-dashes":        html.SmartypantsDashes,
+	"smartypants-latex-dashes":  html.SmartypantsLatexDashes,
+	"smartypants-angled-quotes": html.SmartypantsAngledQuotes,
+	"smartypants-quotes-nbsp":   html.SmartypantsQuotesNBSP,
+	"lazy-load-images":          html.LazyLoadImages,
+}
+
+// SiteConfig is the config of the current build
+var SiteConfig = DefaultConfig()
+
+var siteConfigMu sync.Mutex
+
+func DefaultConfig() *Config {
+	config := &Config{
+		Page: PageConfig{
+			Extensions:    Extensions,
+			RendererFlags: html.CommonFlags,
+			Highlight:     HighlightConfig{Style: "github", LineNumbers: true, TabWidth: 8},
+			TOC:           DefaultTOC,
+			TOCLevels:     DefaultTOCLevels,
+		},
+		Directories: make(map[string]PageConfig),
+		Output:      OutputConfig{Workers: GetBuildWorkers()},
+		Server: ServerConfig{
+			ReloadTransport: reload.TransportAuto,
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    60 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+	}
+	config.Page.compile()
+	return config
+}
+
+// compile creates the highlighting style and formatter of the settings
+func (page *PageConfig) compile() {
+	page.style = styles.Get(page.Highlight.Style)
+	page.darkStyle = nil
+	if page.Highlight.DarkStyle != "" {
+		page.darkStyle = styles.Get(page.Highlight.DarkStyle)
+	}
+	page.formatter = format.New(
+		format.WithClasses(!page.Highlight.InlineStyles),
+		format.Standalone(false),
+		format.WithLineNumbers(page.Highlight.LineNumbers),
+		format.TabWidth(page.Highlight.TabWidth),
+	)
+}
+
+// PageSettings returns the settings for the file relative to the markdown folder
+func (config *Config) PageSettings(relative string) *PageConfig {
+	for directory := filepath.Dir(relative); directory != "." && directory != string(filepath.Separator); directory = filepath.Dir(directory) {
+		if settings, ok := config.Directories[directory]; ok {
+			return &settings
+		}
+	}
+	return &config.Page
+}
+
+// GetConfig returns the config of the current build, it must not be modified
+func GetConfig() *Config {
+	siteConfigMu.Lock()
+	defer siteConfigMu.Unlock()
+	return SiteConfig
+}
+
+// FindConfigFile returns the config file in the root of the markdown folder, or an empty string
+func FindConfigFile() string {
+	for _, name := range ConfigNames {
+		path := SourcePath(name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// IsConfigSource reports if the path relative to the markdown folder is a config file.
+// Config files are never copied into the target folder.
+func IsConfigSource(relative string) bool {
+	return slices.Contains(ConfigNames, relative)
+}
+
+// LoadConfig reads the config file of the markdown folder, without one the defaults are returned
+func LoadConfig() (*Config, error) {
+	file := FindConfigFile()
+	if file == "" {
+		return DefaultConfig(), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	switch filepath.Ext(file) {
+	case ".toml":
+		values, err = ParseTOML(data)
+	case ".json":
+		values, err = ParseJSONConfig(data)
+	default:
+		values, err = ParseYAML(data)
+		var yamlErr *FrontMatterError
+		if errors.As(err, &yamlErr) {
+			err = fmt.Errorf("line %d: %s", yamlErr.Line, yamlErr.Message)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	config, err := DecodeConfig(values)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	config.File = file
+	return config, nil
+}
+
+// ApplyConfig makes the config the one of the current build and sets the output and server settings,
+// unless their environment variable is set
+func ApplyConfig(config *Config) {
+	siteConfigMu.Lock()
+	defer siteConfigMu.Unlock()
+	SiteConfig = config
+
+	if os.Getenv("HTML_TARGET_PATH") == "" {
+		TargetFolder = config.Output.Target
+	}
+	if os.Getenv("PRETTY_URLS") == "" {
+		PrettyURLs = config.Output.PrettyURLs
+	}
+	if os.Getenv("BUILD_DRAFTS") == "" {
+		BuildDrafts = config.Output.Drafts
+	}
+	if os.Getenv("BUILD_WORKERS") == "" {
+		BuildWorkers = config.Output.Workers
+	}
+	if os.Getenv("ADDRESS") == "" {
+		Address = config.Server.Address
+	}
+	if os.Getenv("HOT_RELOAD") == "" {
+		HotReload = config.Server.HotReload
+	}
+	if os.Getenv("ON_DEMAND") == "" {
+		OnDemand = config.Server.OnDemand
+	}
+	OnDemand = OnDemand || TargetFolder == ""
+}
+
+// ReloadConfig loads the changed config file. Only the page settings change, the output and server
+// settings of the running build are kept. On an error the previous config stays in use.
+func ReloadConfig() error {
+	config, err := LoadConfig()
+	SetConfigError(err)
+	if err != nil {
+		return err
+	}
+	siteConfigMu.Lock()
+	defer siteConfigMu.Unlock()
+	if config.Output != SiteConfig.Output || config.Server != SiteConfig.Server {
+		log.Println("Changed output and server settings take effect after a restart")
+	}
+	config.Output = SiteConfig.Output
+	config.Server = SiteConfig.Server
+	SiteConfig = config
+	return nil
+}
+
+/*********************************************
+*** FUNCTIONS FOR DECODING THE CONFIG FILE ***
+**********************************************/
+
+// PageSections are the sections that can be overridden per directory
+var PageSections = []string{"parser", "renderer", "highlight", "toc", "diagrams", "math"}
+
+// DecodeConfig checks the parsed config file and fills a Config from it. Errors name the key as
+// it is written in the file, like "highlight.style" or "directories.docs.parser.enable[1]".
+func DecodeConfig(values map[string]any) (*Config, error) {
+	config := DefaultConfig()
+	for _, key := range SortedKeys(values) {
+		value := values[key]
+		var err error
+		switch key {
+		case "parser", "renderer", "highlight", "toc", "diagrams", "math":
+			err = config.Page.decodeSection(key, key, value)
+		case "output":
+			err = config.Output.decode(key, value)
+		case "server":
+			err = config.Server.decode(key, value)
+		case "directories":
+			continue
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	config.Page.compile()
+
+	if value, ok := values["directories"]; ok {
+		if err := config.decodeDirectories(value); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// decodeDirectories merges every override with the settings of the directory containing it,
+// so parents are decoded before their subdirectories
+func (config *Config) decodeDirectories(value any) error {
+	directories, err := ExpectTable("directories", value)
+	if err != nil {
+		return err
+	}
+	names := SortedKeys(directories)
+	cleaned := make(map[string]string, len(names))
+	for _, name := range names {
+		key := "directories." + name
+		directory := filepath.Clean(filepath.FromSlash(strings.Trim(name, "/")))
+		if directory == "." || directory == ".." || strings.HasPrefix(directory, ".."+string(filepath.Separator)) || filepath.IsAbs(name) {
+			return fmt.Errorf("key %q must be a directory inside the markdown folder", key)
+		}
+		cleaned[name] = directory
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.Count(cleaned[names[i]], string(filepath.Separator)) < strings.Count(cleaned[names[j]], string(filepath.Separator))
+	})
+
+	for _, name := range names {
+		key := "directories." + name
+		sections, err := ExpectTable(key, directories[name])
+		if err != nil {
+			return err
+		}
+		settings := *config.PageSettings(filepath.Join(cleaned[name], "page.md"))
+		for _, section := range SortedKeys(sections) {
+			sectionKey := key + "." + section
+			if !slices.Contains(PageSections, section) {
+				return fmt.Errorf("key %q can not be set per directory, only %s can", sectionKey, strings.Join(PageSections, ", "))
+			}
+			if err := settings.decodeSection(section, sectionKey, sections[section]); err != nil {
+				return err
+			}
+		}
+		settings.compile()
+		config.Directories[cleaned[name]] = settings
+	}
+	return nil
+}
+
+func (page *PageConfig) decodeSection(section string, key string, value any) error {
+	table, err := ExpectTable(key, value)
+	if err != nil {
+		return err
+	}
+	for _, name := range SortedKeys(table) {
+		entryKey := key + "." + name
+		entry := table[name]
+		switch section + "." + name {
+		case "parser.extensions":
+			page.Extensions, err = ExpectFlags(entryKey, entry, ExtensionNames, "extension")
+		case "parser.enable":
+			var extensions parser.Extensions
+			extensions, err = ExpectFlags(entryKey, entry, ExtensionNames, "extension")
+			page.Extensions |= extensions
+		case "parser.disable":
+			var extensions parser.Extensions
+			extensions, err = ExpectFlags(entryKey, entry, ExtensionNames, "extension")
+			page.Extensions &^= extensions
+		case "renderer.flags":
+			page.RendererFlags, err = ExpectFlags(entryKey, entry, RendererFlagNames, "renderer flag")
+		case "renderer.enable":
+			var flags html.Flags
+			flags, err = ExpectFlags(entryKey, entry, RendererFlagNames, "renderer flag")
+			page.RendererFlags |= flags
+		case "renderer.disable":
+			var flags html.Flags
+			flags, err = ExpectFlags(entryKey, entry, RendererFlagNames, "renderer flag")
+			page.RendererFlags &^= flags
+		case "highlight.style":
+			page.Highlight.Style, err = ExpectStyle(entryKey, entry)
+		case "highlight.dark_style":
+			page.Highlight.DarkStyle, err = ExpectStyle(entryKey, entry)
+		case "highlight.line_numbers":
+			page.Highlight.LineNumbers, err = ExpectBool(entryKey, entry)
+		case "highlight.tab_width":
+			page.Highlight.TabWidth, err = ExpectInt(entryKey, entry)
+			if err == nil && page.Highlight.TabWidth < 1 {
+				err = fmt.Errorf("key %q must be at least 1", entryKey)
+			}
+		case "highlight.inline_styles":
+			page.Highlight.InlineStyles, err = ExpectBool(entryKey, entry)
+		case "highlight.detect_language":
+			page.Highlight.DetectLanguage, err = ExpectBool(entryKey, entry)
+		case "toc.enabled":
+			page.TOC, err = ExpectBool(entryKey, entry)
+		case "toc.levels":
+			page.TOCLevels, err = ExpectLevelRange(entryKey, entry)
+			if err == nil && page.TOCLevels.IsZero() {
+				page.TOCLevels = DefaultTOCLevels
+			}
+		case "math.enabled":
+			page.Math.Enabled, err = ExpectBool(entryKey, entry)
+		case "math.scripts":
+			page.Math.Scripts, err = ExpectStringList(entryKey, entry)
+		case "diagrams.mermaid_script":
+			page.Diagrams.MermaidScript, err = ExpectString(entryKey, entry)
+		case "diagrams.mermaid_integrity":
+			page.Diagrams.MermaidIntegrity, err = ExpectString(entryKey, entry)
+		case "diagrams.commands":
+			// the markdown folder must not be able to run programs on the machine building it
+			err = fmt.Errorf("key %q can only be set with DIAGRAM_COMMANDS or --diagram-command", entryKey)
+		default:
+			err = fmt.Errorf("unknown key %q", entryKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (output *OutputConfig) decode(key string, value any) error {
+	table, err := ExpectTable(key, value)
+	if err != nil {
+		return err
+	}
+	for _, name := range SortedKeys(table) {
+		entryKey := key + "." + name
+		entry := table[name]
+		switch name {
+		case "target":
+			output.Target, err = ExpectString(entryKey, entry)
+			if err == nil && output.Target != "" {
+				output.Target, err = ResolveTarget(entryKey, output.Target)
+			}
+		case "pretty_urls":
+			output.PrettyURLs, err = ExpectBool(entryKey, entry)
+		case "drafts":
+			output.Drafts, err = ExpectBool(entryKey, entry)
+		case "workers":
+			output.Workers, err = ExpectInt(entryKey, entry)
+			if err == nil && output.Workers < 1 {
+				err = fmt.Errorf("key %q must be at least 1", entryKey)
+			}
+		default:
+			err = fmt.Errorf("unknown key %q", entryKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveTarget resolves a target folder relative to the markdown folder. The
+// target is cleaned up before a build, so neither folder may contain the other.
+func ResolveTarget(key string, target string) (string, error) {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(AbsolutePath, target)
+	}
+	target = filepath.Clean(target)
+	if IsWithin(AbsolutePath, target) {
+		return "", fmt.Errorf("key %q must be outside of the markdown folder", key)
+	}
+	if IsWithin(target, AbsolutePath) {
+		return "", fmt.Errorf("key %q must not contain the markdown folder", key)
+	}
+	return target, nil
+}
+
+// IsWithin reports whether path is folder or one of its descendants
+func IsWithin(folder string, path string) bool {
+	relative, err := filepath.Rel(folder, path)
+	if err != nil {
+		return false
+	}
+	return relative == "." || (relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)))
+}
+
+func (server *ServerConfig) decode(key string, value any) error {
+	table, err := ExpectTable(key, value)
+	if err != nil {
+		return err
+	}
+	for _, name := range SortedKeys(table) {
+		entryKey := key + "." + name
+		entry := table[name]
+		switch name {
+		case "address":
+			server.Address, err = ExpectString(entryKey, entry)
+		case "hot_reload":
+			server.HotReload, err = ExpectBool(entryKey, entry)
+		case "on_demand":
+			server.OnDemand, err = ExpectBool(entryKey, entry)
+		case "reload_transport":
+			server.ReloadTransport, err = ExpectString(entryKey, entry)
+			transports := []string{reload.TransportAuto, reload.TransportWebSocket, reload.TransportSSE}
+			if err == nil && !slices.Contains(transports, server.ReloadTransport) {
+				err = fmt.Errorf("key %q must be one of %s", entryKey, strings.Join(transports, ", "))
+			}
+		case "read_timeout":
+			server.ReadTimeout, err = ExpectDuration(entryKey, entry)
+		case "write_timeout":
+			server.WriteTimeout, err = ExpectDuration(entryKey, entry)
+		case "idle_timeout":
+			server.IdleTimeout, err = ExpectDuration(entryKey, entry)
+		case "shutdown_timeout":
+			server.ShutdownTimeout, err = ExpectDuration(entryKey, entry)
+		default:
+			err = fmt.Errorf("unknown key %q", entryKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpectDuration checks a duration written like "30s" or "1m30s", negative durations are not allowed
+func ExpectDuration(key string, value any) (time.Duration, error) {
+	text, err := ExpectString(key, value)
+	if err != nil {
+		return 0, err
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("key %q must be a duration like \"30s\" or \"1m30s\"", key)
+	}
+	return duration, nil
+}
+
+// ExpectStyle checks the name of a chroma style, an empty name is only allowed for the dark style
+func ExpectStyle(key string, value any) (string, error) {
+	name, err := ExpectString(key, value)
+	if err != nil || name == "" && strings.HasSuffix(key, "dark_style") {
+		return name, err
+	}
+	if _, ok := styles.Registry[name]; !ok {
+		return "", fmt.Errorf("key %q: unknown style %q, known are %s", key, name, strings.Join(styles.Names(), ", "))
+	}
+	return name, nil
+}
+
+func ExpectTable(key string, value any) (map[string]any, error) {
+	table, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("key %q must be a table of keys", key)
+	}
+	return table, nil
+}
+
+// ExpectFlags combines a list of flag names into their bit set
+func ExpectFlags[T ~int](key string, value any, names map[string]T, kind string) (T, error) {
+	list, err := ExpectStringList(key, value)
+	if err != nil {
+		return 0, err
+	}
+	var result T
+	for i, name := range list {
+		flag, ok := names[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("key \"%s[%d]\": unknown %s %q, known are %s", key, i, kind, name, strings.Join(SortedKeys(names), ", "))
+		}
+		result |= flag
+	}
+	return result, nil
+}
+
+/***********************************************
+*** MINIMAL JSON AND TOML PARSERS FOR CONFIG ***
+************************************************/
+
+// ParseJSONConfig decodes a JSON object into the same value types the YAML and TOML parsers produce
+func ParseJSONConfig(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line := bytes.Count(data[:min(int(syntaxErr.Offset), len(data))], []byte("\n")) + 1
+		return nil, fmt.Errorf("line %d: %s", line, syntaxErr.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	table, ok := normalizeJSON(value).(map[string]any)
+	if !ok {
+		return nil, errors.New("config must be a JSON object")
+	}
+	return table, nil
+}
+
+func normalizeJSON(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if number, err := v.Int64(); err == nil {
+			return number
+		}
+		number, _ := v.Float64()
+		return number
+	case []any:
+		for i := range v {
+			v[i] = normalizeJSON(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = normalizeJSON(v[key])
+		}
+	}
+	return value
+}
+
+// ParseTOML understands the subset of TOML used in config files: tables, dotted and quoted keys,
+// basic and literal strings, integers, floats, booleans, arrays and inline tables.
+func ParseTOML(data []byte) (map[string]any, error) {
+	if !utf8.Valid(data) {
+		return nil, errors.New("config is not valid UTF-8")
+	}
+	p := &tomlParser{text: string(data), line: 1}
+	root := make(map[string]any)
+	current := root
+	defined := make(map[string]bool)
+
+	for {
+		p.skipSpace()
+		if p.done() {
+			return root, nil
+		}
+		switch p.peek() {
+		case '\n', '#':
+			p.skipComment()
+			p.next()
+			continue
+		case '[':
+			p.next()
+			if p.peek() == '[' {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume(']') {
+				return nil, p.errorf("expected ']' after table name")
+			}
+			name := strings.Join(keys, ".")
+			if defined[name] {
+				return nil, p.errorf("table [%s] is defined twice", name)
+			}
+			defined[name] = true
+			current, err = p.table(root, keys)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume('=') {
+				return nil, p.errorf("expected '=' after key %q", strings.Join(keys, "."))
+			}
+			p.skipSpace()
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			table, err := p.table(current, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			last := keys[len(keys)-1]
+			if _, exists := table[last]; exists {
+				return nil, p.errorf("key %q is defined twice", strings.Join(keys, "."))
+			}
+			table[last] = value
+		}
+
+		p.skipSpace()
+		p.skipComment()
+		if !p.done() && !p.consume('\n') {
+			return nil, p.errorf("unexpected %q after value", p.peek())
+		}
+	}
+}
+
+type tomlParser struct {
+	text string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) done() bool {
+	return p.pos >= len(p.text)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+func (p *tomlParser) next() byte {
+	c := p.peek()
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *tomlParser) consume(c byte) bool {
+	if p.peek() != c || p.done() {
+		return false
+	}
+	p.next()
+	return true
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\r' {
+		p.next()
+	}
+}
+
+func (p *tomlParser) skipComment() {
+	if p.peek() == '#' {
+		for !p.done() && p.peek() != '\n' {
+			p.next()
+		}
+	}
+}
+
+// skipBlank skips whitespace, newlines and comments inside of arrays
+func (p *tomlParser) skipBlank() {
+	for !p.done() {
+		switch p.peek() {
+		case ' ', '\t', '\r', '\n':
+			p.next()
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+// table returns the nested table for the keys below parent, creating missing ones
+func (p *tomlParser) table(parent map[string]any, keys []string) (map[string]any, error) {
+	for _, key := range keys {
+		value, exists := parent[key]
+		if !exists {
+			table := make(map[string]any)
+			parent[key] = table
+			parent = table
+			continue
+		}
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil, p.errorf("key %q is not a table", key)
+		}
+		parent = table
+	}
+	return parent, nil
+}
+
+func (p *tomlParser) parseKey() ([]string, error) {
+	keys := make([]string, 0)
+	for {
+		p.skipSpace()
+		var key string
+		switch p.peek() {
+		case '"', '\'':
+			var err error
+			key, err = p.parseString()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			start := p.pos
+			for c := p.peek(); c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'; c = p.peek() {
+				p.next()
+			}
+			if start == p.pos {
+				return nil, p.errorf("expected a key")
+			}
+			key = p.text[start:p.pos]
+		}
+		keys = append(keys, key)
+		p.skipSpace()
+		if !p.consume('.') {
+			return keys, nil
+		}
+	}
+}
+
+func (p *tomlParser) parseValue() (any, error) {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.parseString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return p.parseInlineTable()
+	case strings.HasPrefix(p.text[p.pos:], "true"):
+		p.pos += len("true")
+		return true, nil
+	case strings.HasPrefix(p.text[p.pos:], "false"):
+		p.pos += len("false")
+		return false, nil
+	default:
+		start := p.pos
+		for c := p.peek(); c == '+' || c == '-' || c == '_' || c == '.' || c == 'e' || c == 'E' || c >= '0' && c <= '9'; c = p.peek() {
+			p.next()
+		}
+		text := strings.ReplaceAll(p.text[start:p.pos], "_", "")
+		if text == "" {
+			return nil, p.errorf("expected a value")
+		}
+		if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return number, nil
+		}
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			return number, nil
+		}
+		return nil, p.errorf("%q is not a number", text)
+	}
+}
+
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.next()
+	if strings.HasPrefix(p.text[p.pos:], string([]byte{quote, quote})) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	var result strings.Builder
+	for {
+		if p.done() || p.peek() == '\n' {
+			return "", p.errorf("string is never closed")
+		}
+		c := p.next()
+		if c == quote {
+			return result.String(), nil
+		}
+		if c != '\\' || quote == '\'' {
+			result.WriteByte(c)
+			continue
+		}
+		switch escape := p.next(); escape {
+		case 'n':
+			result.WriteByte('\n')
+		case 't':
+			result.WriteByte('\t')
+		case 'r':
+			result.WriteByte('\r')
+		case '"', '\\':
+			result.WriteByte(escape)
+		case 'u':
+			if p.pos+4 > len(p.text) {
+				return "", p.errorf("incomplete unicode escape")
+			}
+			code, err := strconv.ParseUint(p.text[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				return "", p.errorf("invalid unicode escape")
+			}
+			p.pos += 4
+			result.WriteRune(rune(code))
+		default:
+			return "", p.errorf("unknown escape \\%c", escape)
+		}
+	}
+}
+
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.next()
+	result := make([]any, 0)
+	for {
+		p.skipBlank()
+		if p.consume(']') {
+			return result, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+		p.skipBlank()
+		if p.consume(']') {
+			return result, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]any, error) {
+	p.next()
+	result := make(map[string]any)
+	p.skipSpace()
+	if p.consume('}') {
+		return result, nil
+	}
+	for {
+		keys, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after key %q", strings.Join(keys, "."))
+		}
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		table, err := p.table(result, keys[:len(keys)-1])
+		if err != nil {
+			return nil, err
+		}
+		table[keys[len(keys)-1]] = value
+		p.skipSpace()
+		if p.consume('}') {
+			return result, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+		p.skipSpace()
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveTarget(t *testing.T) {
+	root := t.TempDir()
+	saved := AbsolutePath
+	AbsolutePath = filepath.Join(root, "docs")
+	defer func() { AbsolutePath = saved }()
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{name: "sibling", target: "../site", want: filepath.Join(root, "site")},
+		{name: "absolute", target: filepath.Join(root, "out"), want: filepath.Join(root, "out")},
+		{name: "similar prefix", target: "../docs-site", want: filepath.Join(root, "docs-site")},
+		{name: "same folder", target: "."},
+		{name: "inside", target: "site"},
+		{name: "parent", target: ".."},
+		{name: "ancestor", target: root},
+		{name: "root", target: string(filepath.Separator)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolveTarget("output.target", test.target)
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("ResolveTarget(%q) = %q, want an error", test.target, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveTarget(%q) error = %v", test.target, err)
+			}
+			if got != test.want {
+				t.Errorf("ResolveTarget(%q) = %q, want %q", test.target, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want map[string]any
+	}{
+		{
+			name: "scalars",
+			toml: "title = \"Hello\"\nliteral = 'C:\\docs'\ncount = 1_000\nratio = 1.5\ndraft = false\n",
+			want: map[string]any{
+				"title":   "Hello",
+				"literal": "C:\\docs",
+				"count":   int64(1000),
+				"ratio":   1.5,
+				"draft":   false,
+			},
+		},
+		{
+			name: "escapes",
+			toml: "text = \"a\\tb\\n\\\"c\\\" \\u00e9\"\n",
+			want: map[string]any{"text": "a\tb\n\"c\" \u00e9"},
+		},
+		{
+			name: "comments",
+			toml: "# leading comment\n\ntitle = \"a # b\" # trailing comment\n",
+			want: map[string]any{"title": "a # b"},
+		},
+		{
+			name: "tables",
+			toml: "[highlight]\nstyle = \"monokai\"\n\n[directories.\"docs/api\".toc]\nenabled = true\n",
+			want: map[string]any{
+				"highlight": map[string]any{"style": "monokai"},
+				"directories": map[string]any{
+					"docs/api": map[string]any{"toc": map[string]any{"enabled": true}},
+				},
+			},
+		},
+		{
+			name: "dotted keys",
+			toml: "server.address = \":8080\"\n[output]\nnested.key = 1\n",
+			want: map[string]any{
+				"server": map[string]any{"address": ":8080"},
+				"output": map[string]any{"nested": map[string]any{"key": int64(1)}},
+			},
+		},
+		{
+			name: "arrays",
+			toml: "enable = [\n  \"tables\", # comment\n  \"footnotes\",\n]\nempty = []\nnested = [[1, 2], ['a']]\n",
+			want: map[string]any{
+				"enable": []any{"tables", "footnotes"},
+				"empty":  []any{},
+				"nested": []any{[]any{int64(1), int64(2)}, []any{"a"}},
+			},
+		},
+		{
+			name: "inline tables",
+			toml: "levels = { min = 2, max = 3 }\nempty = {}\n",
+			want: map[string]any{
+				"levels": map[string]any{"min": int64(2), "max": int64(3)},
+				"empty":  map[string]any{},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseTOML([]byte(test.toml))
+			if err != nil {
+				t.Fatalf("ParseTOML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseTOML() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		line int
+	}{
+		{name: "duplicate key", toml: "a = 1\na = 2\n", line: 2},
+		{name: "duplicate table", toml: "[a]\nx = 1\n[a]\n", line: 3},
+		{name: "key is not a table", toml: "a = 1\n[a.b]\n", line: 2},
+		{name: "missing equals", toml: "\ntitle \"a\"\n", line: 2},
+		{name: "missing value", toml: "a =\n", line: 1},
+		{name: "unclosed string", toml: "a = \"open\n", line: 1},
+		{name: "unclosed array", toml: "a = [1,\n2\n", line: 3},
+		{name: "text after value", toml: "a = 1 b\n", line: 1},
+		{name: "not a number", toml: "a = 1.2.3\n", line: 1},
+		{name: "unknown escape", toml: "a = \"\\q\"\n", line: 1},
+		{name: "multi-line string", toml: "a = \"\"\"text\"\"\"\n", line: 1},
+		{name: "array of tables", toml: "x = 1\n[[a]]\n", line: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseTOML([]byte(test.toml))
+			if err == nil {
+				t.Fatal("ParseTOML() error = nil, want an error")
+			}
+			if prefix := fmt.Sprintf("line %d:", test.line); !strings.HasPrefix(err.Error(), prefix) {
+				t.Errorf("ParseTOML() error = %v, want it on line %d", err, test.line)
+			}
+		})
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	values, err := ParseTOML([]byte("[highlight]\nstyle = \"monokai\"\n\n" +
+		"[directories.docs.highlight]\nline_numbers = false\n\n" +
+		"[directories.\"docs/api\".toc]\nenabled = true\nlevels = \"2-3\"\n"))
+	if err != nil {
+		t.Fatalf("ParseTOML() error = %v", err)
+	}
+	config, err := DecodeConfig(values)
+	if err != nil {
+		t.Fatalf("DecodeConfig() error = %v", err)
+	}
+	if config.Page.Highlight.Style != "monokai" || !config.Page.Highlight.LineNumbers {
+		t.Errorf("DecodeConfig() page highlight = %+v", config.Page.Highlight)
+	}
+	docs := config.PageSettings(filepath.Join("docs", "index.md"))
+	if docs.Highlight.Style != "monokai" || docs.Highlight.LineNumbers || docs.TOC {
+		t.Errorf("DecodeConfig() docs settings = %+v", docs)
+	}
+	api := config.PageSettings(filepath.Join("docs", "api", "nested", "index.md"))
+	want := LevelRange{Min: 2, Max: 3}
+	if api.Highlight.Style != "monokai" || api.Highlight.LineNumbers || !api.TOC || api.TOCLevels != want {
+		t.Errorf("DecodeConfig() docs/api settings = %+v", api)
+	}
+}
+
+func TestDecodeConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		key  string
+	}{
+		{name: "unknown section", toml: "unknown = 1\n", key: "unknown"},
+		{name: "section is not a table", toml: "server = 1\n", key: "server"},
+		{name: "unknown key", toml: "[highlight]\ncolor = \"red\"\n", key: "highlight.color"},
+		{name: "unknown style", toml: "[highlight]\nstyle = \"nope\"\n", key: "highlight.style"},
+		{name: "small tab width", toml: "[highlight]\ntab_width = 0\n", key: "highlight.tab_width"},
+		{name: "unknown extension", toml: "[parser]\nenable = [\"tables\", \"nope\"]\n", key: "parser.enable[1]"},
+		{name: "not a bool", toml: "[toc]\nenabled = \"yes\"\n", key: "toc.enabled"},
+		{name: "diagram commands", toml: "[diagrams]\ncommands = { dot = \"dot\" }\n", key: "diagrams.commands"},
+		{name: "not a whole number", toml: "[output]\nworkers = \"two\"\n", key: "output.workers"},
+		{name: "bad duration", toml: "[server]\nread_timeout = \"soon\"\n", key: "server.read_timeout"},
+		{name: "unknown transport", toml: "[server]\nreload_transport = \"pigeon\"\n", key: "server.reload_transport"},
+		{name: "directory outside", toml: "[directories.\"../up\".toc]\nenabled = true\n", key: "directories.../up"},
+		{name: "directory section", toml: "[directories.docs.output]\ndrafts = true\n", key: "directories.docs.output"},
+		{name: "directory key", toml: "[directories.docs.highlight]\nstyle = \"nope\"\n", key: "directories.docs.highlight.style"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values, err := ParseTOML([]byte(test.toml))
+			if err != nil {
+				t.Fatalf("ParseTOML() error = %v", err)
+			}
+			_, err = DecodeConfig(values)
+			if err == nil {
+				t.Fatal("DecodeConfig() error = nil, want an error")
+			}
+			if !strings.Contains(err.Error(), fmt.Sprintf("key %q", test.key)) {
+				t.Errorf("DecodeConfig() error = %v, want it to name key %q", err, test.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+)
+
+/*******************************************
+*** FUNCTIONS FOR COLLECTING WARNINGS ***
+********************************************/
+
+// Warning is a problem of a page that was built anyway, like a code block that could not be highlighted
+type Warning struct {
+	File string
+	// Line is the line in the markdown file, or 0 if it is unknown
+	Line    int
+	Message string
+	// Error is set if the page could not be built at all, the build reports these itself
+	Error bool
+}
+
+// PageError returns the warning for a page that failed to build
+func PageError(src string, err error) Warning {
+	warning := Warning{File: src, Message: err.Error(), Error: true}
+	var frontMatterErr *FrontMatterError
+	if errors.As(err, &frontMatterErr) {
+		warning.Line = frontMatterErr.Line
+	}
+	return warning
+}
+
+func (warning Warning) String() string {
+	if warning.Line == 0 {
+		return fmt.Sprintf("%s: %s", warning.File, warning.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", warning.File, warning.Line, warning.Message)
+}
+
+// RenderContext is the state of rendering a single page, shared by the render hooks of its nodes
+type RenderContext struct {
+	Settings *PageConfig
+	// Scripts are loaded by the layout for the nodes of the page, like diagrams and formulas
+	Scripts  []Script
+	Warnings []Warning
+
+	file string
+	// lines finds the code blocks in the markdown file, they are rendered in the order they are written
+	lines *LineLocator
+	// blocks counts the code blocks of the page, so their line numbers get unique ids
+	blocks int
+	// mathWarned is set once the page was warned that no math script is set
+	mathWarned bool
+}
+
+// NewRenderContext starts rendering the markdown file src with its full text, including the front matter
+func NewRenderContext(src string, settings *PageConfig, fullText []byte) *RenderContext {
+	return &RenderContext{Settings: settings, file: src, lines: &LineLocator{text: fullText}}
+}
+
+// AddScripts adds the scripts to the scripts of the page, unless they are already loaded
+func (ctx *RenderContext) AddScripts(scripts ...Script) {
+	for _, script := range scripts {
+		if !slices.Contains(ctx.Scripts, script) {
+			ctx.Scripts = append(ctx.Scripts, script)
+		}
+	}
+}
+
+// Warn records a warning for the line of the markdown file
+func (ctx *RenderContext) Warn(line int, format string, args ...any) {
+	ctx.Warnings = append(ctx.Warnings, Warning{File: ctx.file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// WarningIndex holds the warnings of every page of the site by its path relative to the markdown folder
+type WarningIndex struct {
+	mu    sync.Mutex
+	pages map[string][]Warning
+}
+
+// SiteWarnings are the warnings of the current build, they are replaced on every full rebuild
+var SiteWarnings = NewWarningIndex()
+
+func NewWarningIndex() *WarningIndex {
+	return &WarningIndex{pages: make(map[string][]Warning)}
+}
+
+// Set replaces the warnings of the page, a page without warnings is removed.
+// It reports whether the warnings of the page changed.
+func (index *WarningIndex) Set(relative string, warnings []Warning) bool {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	changed := !slices.Equal(index.pages[relative], warnings)
+	if len(warnings) == 0 {
+		delete(index.pages, relative)
+		return changed
+	}
+	index.pages[relative] = warnings
+	return changed
+}
+
+// Remove deletes the warnings of the page or directory
+func (index *WarningIndex) Remove(relative string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	RemoveInside(index.pages, relative)
+}
+
+// All returns the warnings of every page sorted by file, the warnings of a page keep their order
+func (index *WarningIndex) All() []Warning {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	warnings := make([]Warning, 0)
+	for _, relative := range SortedKeys(index.pages) {
+		warnings = append(warnings, index.pages[relative]...)
+	}
+	return warnings
+}
+
+// SetSourceError records the error of a source that is not a page, like the config file or the layouts,
+// so it is reported with the failed pages until it is fixed. A nil error removes it.
+func SetSourceError(relative string, err error) {
+	if err == nil {
+		SiteWarnings.Set(relative, nil)
+		return
+	}
+	SiteWarnings.Set(relative, []Warning{PageError(SourcePath(relative), err)})
+}
+
+// SetConfigError records the error of loading the config file, a nil error removes it
+func SetConfigError(err error) {
+	for _, name := range ConfigNames {
+		SiteWarnings.Set(name, nil)
+	}
+	if file := FindConfigFile(); err != nil && file != "" {
+		SetSourceError(mustRelative(file), err)
+	}
+}
+
+// LogWarnings prints the warnings of the current build, failed pages are already reported by the build
+func LogWarnings() {
+	count := 0
+	for _, warning := range SiteWarnings.All() {
+		if !warning.Error {
+			log.Println(warning)
+			count++
+		}
+	}
+	if count != 0 {
+		log.Printf("Build has %d warnings", count)
+	}
+}
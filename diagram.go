@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"markdown-server/markdown"
+	"markdown-server/markdown/ast"
+	"markdown-server/markdown/parser"
+)
+
+/*******************************************
+*** FUNCTIONS FOR RENDERING DIAGRAMS ***
+********************************************/
+
+// DiagramConfig holds the settings for fenced blocks that are rendered as diagrams
+type DiagramConfig struct {
+	// MermaidScript is loaded by pages containing a mermaid block. No script is bundled, without one
+	// mermaid blocks are shown as an error.
+	MermaidScript string
+	// MermaidIntegrity is the subresource integrity of the mermaid script, like "sha384-…".
+	// It should be set for a script from a CDN, whose URL must then name an exact version.
+	MermaidIntegrity string
+}
+
+// DiagramCommandLanguages are the diagram languages converted to SVG by a command, like "dot -Tsvg" for dot
+// or "plantuml -tsvg -pipe" for plantuml. The block is written to the standard input of the command and
+// the SVG is read from its standard output. No command runs by default, plantuml for one can read local
+// files and fetch URLs named in a diagram.
+var DiagramCommandLanguages = []string{"dot", "plantuml"}
+
+// DiagramCommands are the commands of the current build. They are only set with DIAGRAM_COMMANDS or
+// --diagram-command, never by the markdown folder, as they run programs on the machine building it.
+var DiagramCommands = GetDiagramCommands()
+
+// GetDiagramCommands reads DIAGRAM_COMMANDS like "dot=dot -Tsvg;plantuml=plantuml -tsvg -pipe",
+// an empty command turns a language off
+func GetDiagramCommands() map[string]string {
+	commands := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("DIAGRAM_COMMANDS"), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		language, command, err := ParseDiagramCommand(entry)
+		if err != nil {
+			log.Printf("DIAGRAM_COMMANDS: %v", err)
+			continue
+		}
+		commands[language] = command
+	}
+	return commands
+}
+
+// ParseDiagramCommand reads a "language=command" entry
+func ParseDiagramCommand(entry string) (string, string, error) {
+	language, command, found := strings.Cut(entry, "=")
+	language = DiagramLanguage(strings.TrimSpace(language))
+	if !found {
+		return "", "", fmt.Errorf("%q is not like \"dot=dot -Tsvg\"", entry)
+	}
+	if !slices.Contains(DiagramCommandLanguages, language) {
+		return "", "", fmt.Errorf("commands can only be set for %s, not for %q",
+			strings.Join(DiagramCommandLanguages, " and "), language)
+	}
+	return language, strings.TrimSpace(command), nil
+}
+
+// DiagramTimeout is how long a diagram command may run before it is stopped
+const DiagramTimeout = 30 * time.Second
+
+// FencedRenderer writes the HTML for the code of a fenced block instead of highlighting it into a buffer.
+// The returned error is rendered in place of the block.
+type FencedRenderer func(w io.Writer, block *FencedBlock) error
+
+// FencedBlock is a fenced code block handed to a FencedRenderer
+type FencedBlock struct {
+	Info   CodeInfo
+	Source []byte
+	// Line is the line of the block in the markdown file, or 0 if it is unknown
+	Line int
+	// Page is the context of the page the block is rendered for, the block adds the scripts it needs to it
+	Page *RenderContext
+}
+
+// FencedRenderers maps the language of a fenced block to the renderer used for it
+var FencedRenderers = map[string]FencedRenderer{
+	"mermaid":  RenderMermaid,
+	"dot":      RenderDiagramCommand,
+	"graphviz": RenderDiagramCommand,
+	"plantuml": RenderDiagramCommand,
+	"puml":     RenderDiagramCommand,
+}
+
+// diagramLanguages maps the aliases of the diagram languages to the name used in DIAGRAM_COMMANDS
+var diagramLanguages = map[string]string{
+	"graphviz": "dot",
+	"puml":     "plantuml",
+}
+
+// DiagramLanguage returns the name of the diagram language used for the config and the css classes
+func DiagramLanguage(language string) string {
+	language = strings.ToLower(language)
+	if name, ok := diagramLanguages[language]; ok {
+		return name
+	}
+	return language
+}
+
+// RenderFencedBlock writes the block with the renderer registered for its language. It reports false
+// if there is none. A failing renderer is shown as an error followed by the escaped code and reported as
+// a warning of the page.
+func RenderFencedBlock(w *bytes.Buffer, block *FencedBlock) bool {
+	renderer := FencedRenderers[strings.ToLower(block.Info.Language)]
+	if renderer == nil {
+		return false
+	}
+	var output bytes.Buffer
+	if err := renderer(&output, block); err != nil {
+		message := fmt.Sprintf("diagram %q could not be rendered: %v", block.Info.Language, err)
+		block.Page.Warn(block.Line, "%s", message)
+		w.WriteString(`<div class="diagram-error"><p>`)
+		_ = EscapeHTML(w, []byte(message))
+		w.WriteString("</p>\n")
+		WritePlainCode(w, block.Source)
+		w.WriteString("</div>")
+		return true
+	}
+	_, _ = w.Write(output.Bytes())
+	return true
+}
+
+// RenderMermaid writes the container rendered by the mermaid script in the browser
+func RenderMermaid(w io.Writer, block *FencedBlock) error {
+	settings := block.Page.Settings.Diagrams
+	if settings.MermaidScript == "" {
+		return errors.New("diagrams.mermaid_script is not set")
+	}
+	block.Page.AddScripts(Script{Src: settings.MermaidScript, Integrity: settings.MermaidIntegrity})
+	_, _ = w.Write([]byte(`<pre class="diagram diagram-mermaid mermaid">`))
+	_ = EscapeHTML(w, bytes.TrimSpace(block.Source))
+	_, _ = w.Write([]byte("</pre>"))
+	return nil
+}
+
+// RenderDiagramCommand converts the block to SVG with the command of its language
+func RenderDiagramCommand(w io.Writer, block *FencedBlock) error {
+	language := DiagramLanguage(block.Info.Language)
+	svg, err := RunDiagramCommand(language, block.Source)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, `<div class="diagram diagram-%s">`, language)
+	_, _ = w.Write(svg)
+	_, _ = w.Write([]byte("</div>"))
+	return nil
+}
+
+// diagramCache keeps the results of the diagram commands by command and source, so unchanged diagrams
+// are not converted again. Errors are only kept until they are looked up once, so a fixed command runs again.
+var diagramCache = struct {
+	mu      sync.Mutex
+	results map[[sha256.Size]byte]diagramResult
+}{results: make(map[[sha256.Size]byte]diagramResult)}
+
+type diagramResult struct {
+	svg []byte
+	err error
+}
+
+// diagramCacheSize is the number of results kept before the cache starts over
+const diagramCacheSize = 256
+
+// RunDiagramCommand returns the SVG the command of the language converts the source to
+func RunDiagramCommand(language string, source []byte) ([]byte, error) {
+	command := strings.Fields(DiagramCommands[language])
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no command is set for %s diagrams, it can be set with DIAGRAM_COMMANDS or --diagram-command", language)
+	}
+	key := sha256.Sum256(slices.Concat([]byte(strings.Join(command, " ")), []byte{0}, source))
+	diagramCache.mu.Lock()
+	result, ok := diagramCache.results[key]
+	if ok && result.err != nil {
+		delete(diagramCache.results, key)
+	}
+	diagramCache.mu.Unlock()
+	if ok {
+		return result.svg, result.err
+	}
+
+	result.svg, result.err = runDiagramCommand(command, source)
+	diagramCache.mu.Lock()
+	defer diagramCache.mu.Unlock()
+	if len(diagramCache.results) >= diagramCacheSize {
+		clear(diagramCache.results)
+	}
+	diagramCache.results[key] = result
+	return result.svg, result.err
+}
+
+func runDiagramCommand(command []string, source []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DiagramTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(source)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s: %w: %s", command[0], err, message)
+		}
+		return nil, fmt.Errorf("%s: %w", command[0], err)
+	}
+
+	// the xml declaration and doctype are not allowed inside of a html page
+	svg := stdout.Bytes()
+	start := bytes.Index(svg, []byte("<svg"))
+	if start == -1 {
+		return nil, fmt.Errorf("%s did not write an SVG image", command[0])
+	}
+	return bytes.TrimSpace(svg[start:]), nil
+}
+
+// PrepareDiagrams runs the diagram commands of a markdown file ahead of rendering it, so rendering finds
+// their results in the cache. The on demand handler calls it before it takes the render lock, as the
+// commands can run until the DiagramTimeout.
+func PrepareDiagrams(src string, markdownText []byte) {
+	_, body := SplitFrontMatter(markdown.NormalizeNewlines(markdownText))
+	settings := GetConfig().PageSettings(mustRelative(src))
+	// blocks of included files are converted while rendering
+	doc := markdown.Parse(body, parser.NewWithExtensions(settings.ParserExtensions()&^parser.Includes))
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		block, ok := node.(*ast.CodeBlock)
+		if !ok || !entering || len(block.Info) == 0 {
+			return ast.GoToNext
+		}
+		info, err := ParseCodeInfo(string(block.Info))
+		if err != nil {
+			return ast.GoToNext
+		}
+		language := DiagramLanguage(info.Language)
+		if DiagramCommands[language] != "" {
+			_, _ = RunDiagramCommand(language, block.Literal)
+		}
+		return ast.GoToNext
+	})
+}
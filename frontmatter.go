@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*********************************************
+*** FUNCTIONS FOR PARSING YAML FRONT MATTER ***
+**********************************************/
+
+// PageMeta holds the front matter of a markdown page
+type PageMeta struct {
+	Title       string
+	Description string
+	Author      string
+	Date        time.Time
+	Tags        []string
+	Draft       bool
+	Layout      string
+	Lang        string
+	// Weight orders the page in the navigation, pages without a weight come last
+	Weight int
+	// TOC places the table of contents in the layout, nil falls back to the TOC variable
+	TOC *bool
+	// TOCLevels limits the headings of the table of contents, zero falls back to TOC_LEVELS
+	TOCLevels LevelRange
+	// Custom contains every key that is not one of the fields above
+	Custom map[string]any
+}
+
+const DefaultLang = "de"
+
+var FrontMatterDelimiter = []byte("---")
+
+var DateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// FrontMatterError points at the line of the front matter that could not be parsed
+type FrontMatterError struct {
+	Line    int
+	Message string
+}
+
+func (e *FrontMatterError) Error() string {
+	return fmt.Sprintf("front matter line %d: %s", e.Line, e.Message)
+}
+
+// SplitFrontMatter separates a leading "---" delimited block from the markdown body.
+// If no block exists the front matter is nil and the body is the unchanged input, this includes
+// a page starting with a "---" rule that is never closed.
+func SplitFrontMatter(markdownText []byte) (frontMatter []byte, body []byte) {
+	text := bytes.TrimPrefix(markdownText, []byte("\xef\xbb\xbf"))
+	firstLine, rest, _ := bytes.Cut(text, []byte("\n"))
+	if !bytes.Equal(bytes.TrimRight(firstLine, " \t\r"), FrontMatterDelimiter) {
+		return nil, markdownText
+	}
+
+	pos := 0
+	for pos <= len(rest) {
+		end := bytes.IndexByte(rest[pos:], '\n')
+		if end == -1 {
+			end = len(rest) - pos
+		}
+		line := bytes.TrimRight(rest[pos:pos+end], " \t\r")
+		if bytes.Equal(line, FrontMatterDelimiter) || bytes.Equal(line, []byte("...")) {
+			bodyStart := min(pos+end+1, len(rest))
+			return rest[:pos], rest[bodyStart:]
+		}
+		pos += end + 1
+	}
+	return nil, markdownText
+}
+
+// ParseFrontMatter splits the markdown text and fills a PageMeta from its front matter
+func ParseFrontMatter(markdownText []byte) (PageMeta, []byte, error) {
+	meta := PageMeta{Lang: DefaultLang, Custom: map[string]any{}}
+	frontMatter, body := SplitFrontMatter(markdownText)
+	if frontMatter == nil {
+		return meta, body, nil
+	}
+
+	values, err := ParseYAML(frontMatter)
+	var yamlErr *FrontMatterError
+	if errors.As(err, &yamlErr) {
+		// count the opening delimiter so the line matches the markdown file
+		yamlErr.Line++
+	}
+	if err != nil {
+		return meta, body, err
+	}
+	err = meta.Fill(values)
+	return meta, body, err
+}
+
+// Fill maps the parsed YAML values onto the typed fields, unknown keys end up in Custom
+func (meta *PageMeta) Fill(values map[string]any) error {
+	for _, key := range SortedKeys(values) {
+		value := values[key]
+		var err error
+		switch strings.ToLower(key) {
+		case "title":
+			meta.Title, err = ExpectString(key, value)
+		case "description":
+			meta.Description, err = ExpectString(key, value)
+		case "author":
+			meta.Author, err = ExpectString(key, value)
+		case "layout":
+			meta.Layout, err = ExpectString(key, value)
+		case "lang":
+			meta.Lang, err = ExpectString(key, value)
+		case "draft":
+			meta.Draft, err = ExpectBool(key, value)
+		case "date":
+			meta.Date, err = ExpectDate(key, value)
+		case "tags":
+			meta.Tags, err = ExpectStringList(key, value)
+		case "weight":
+			meta.Weight, err = ExpectInt(key, value)
+		case "toc":
+			var toc bool
+			toc, err = ExpectBool(key, value)
+			meta.TOC = &toc
+		case "toc_levels":
+			meta.TOCLevels, err = ExpectLevelRange(key, value)
+		default:
+			meta.Custom[key] = value
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ExpectString(key string, value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	case []any, map[string]any:
+		return "", fmt.Errorf("key %q must be a single value", key)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+func ExpectBool(key string, value any) (bool, error) {
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("key %q must be true or false", key)
+	}
+	return result, nil
+}
+
+func ExpectInt(key string, value any) (int, error) {
+	result, ok := value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("key %q must be a whole number", key)
+	}
+	return int(result), nil
+}
+
+func ExpectDate(key string, value any) (time.Time, error) {
+	text, err := ExpectString(key, value)
+	if err != nil || text == "" {
+		return time.Time{}, err
+	}
+	for _, format := range DateFormats {
+		if date, err := time.Parse(format, text); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("key %q has an unknown date format %q", key, text)
+}
+
+func ExpectStringList(key string, value any) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		// allow the common shorthand "tags: a, b"
+		result := make([]string, 0)
+		for _, entry := range strings.Split(v, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				result = append(result, entry)
+			}
+		}
+		return result, nil
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, entry := range v {
+			text, err := ExpectString(key, entry)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, text)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("key %q must be a list", key)
+	}
+}
+
+func SortedKeys[T any](values map[string]T) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/********************************************
+*** MINIMAL YAML PARSER FOR FRONT MATTER ***
+*********************************************/
+
+// yamlLine is a single meaningful line of a YAML document
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// ParseYAML understands the subset of YAML used in front matter: nested mappings,
+// block and flow sequences, quoted and plain scalars, literal (|) and folded (>) blocks.
+func ParseYAML(data []byte) (map[string]any, error) {
+	lines := make([]yamlLine, 0)
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, &FrontMatterError{Line: i + 1, Message: "tabs are not allowed for indentation"}
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(raw) - len(trimmed), text: trimmed})
+	}
+
+	parser := &yamlParser{lines: lines}
+	parser.skipEmpty()
+	if parser.pos >= len(parser.lines) {
+		return map[string]any{}, nil
+	}
+	value, err := parser.parseMapping(parser.lines[parser.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	parser.skipEmpty()
+	if parser.pos < len(parser.lines) {
+		return nil, parser.errorf("unexpected indentation")
+	}
+	return value, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+	// valueLine is the line number of the key or list entry whose value is parsed
+	valueLine int
+}
+
+func (p *yamlParser) errorf(format string, v ...any) error {
+	line := len(p.lines)
+	if p.pos < len(p.lines) {
+		line = p.lines[p.pos].number
+	}
+	return &FrontMatterError{Line: line, Message: fmt.Sprintf(format, v...)}
+}
+
+func (p *yamlParser) valueErrorf(format string, v ...any) error {
+	return &FrontMatterError{Line: p.valueLine, Message: fmt.Sprintf(format, v...)}
+}
+
+func (p *yamlParser) skipEmpty() {
+	for p.pos < len(p.lines) {
+		text := p.lines[p.pos].text
+		if text != "" && !strings.HasPrefix(text, "#") {
+			return
+		}
+		p.pos++
+	}
+}
+
+// next returns the next meaningful line if it is indented by exactly indent spaces
+func (p *yamlParser) next(indent int) (yamlLine, bool) {
+	p.skipEmpty()
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent != indent {
+		return yamlLine{}, false
+	}
+	return p.lines[p.pos], true
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]any, error) {
+	result := map[string]any{}
+	for {
+		line, ok := p.next(indent)
+		if !ok {
+			return result, nil
+		}
+		if strings.HasPrefix(line.text, "- ") || line.text == "-" {
+			return nil, p.errorf("expected a key but found a list entry")
+		}
+		key, rest, found := CutYAMLKey(line.text)
+		if !found {
+			return nil, p.errorf("expected \"key: value\" but found %q", line.text)
+		}
+		if _, exists := result[key]; exists {
+			return nil, p.errorf("key %q is defined more than once", key)
+		}
+		p.pos++
+		p.valueLine = line.number
+		value, err := p.parseValue(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]any, error) {
+	result := make([]any, 0)
+	for {
+		line, ok := p.next(indent)
+		if !ok || !(strings.HasPrefix(line.text, "- ") || line.text == "-") {
+			return result, nil
+		}
+		rest := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if _, _, found := CutYAMLKey(rest); found {
+			// a mapping that starts on the same line as the list entry
+			entryIndent := indent + len(line.text) - len(rest)
+			p.lines[p.pos] = yamlLine{number: line.number, indent: entryIndent, text: rest}
+			mapping, err := p.parseMapping(entryIndent)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, mapping)
+			continue
+		}
+		p.pos++
+		p.valueLine = line.number
+		value, err := p.parseValue(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+}
+
+// parseValue parses the value after a "key:" or "- ", continuing on indented lines if it is empty
+func (p *yamlParser) parseValue(rest string, parentIndent int) (any, error) {
+	rest = StripYAMLComment(rest)
+	switch {
+	case rest == "":
+		p.skipEmpty()
+		if p.pos >= len(p.lines) {
+			return nil, nil
+		}
+		line := p.lines[p.pos]
+		if strings.HasPrefix(line.text, "- ") || line.text == "-" {
+			// sequences are allowed on the same indentation as their key
+			if line.indent >= parentIndent {
+				return p.parseSequence(line.indent)
+			}
+			return nil, nil
+		}
+		if line.indent <= parentIndent {
+			return nil, nil
+		}
+		return p.parseMapping(line.indent)
+	case rest == "|" || rest == ">" || rest == "|-" || rest == ">-":
+		return p.parseBlockScalar(rest, parentIndent)
+	case strings.HasPrefix(rest, "["):
+		return p.parseFlowSequence(rest)
+	default:
+		return p.parseScalar(rest)
+	}
+}
+
+func (p *yamlParser) parseBlockScalar(style string, parentIndent int) (string, error) {
+	blockLines := make([]string, 0)
+	blockIndent := -1
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.text != "" && line.indent <= parentIndent {
+			break
+		}
+		if line.text != "" && blockIndent == -1 {
+			blockIndent = line.indent
+		}
+		if line.text != "" && line.indent < blockIndent {
+			return "", p.errorf("line is indented less than the first line of the block")
+		}
+		text := ""
+		if line.text != "" {
+			text = strings.Repeat(" ", line.indent-blockIndent) + line.text
+		}
+		blockLines = append(blockLines, text)
+		p.pos++
+	}
+	for len(blockLines) > 0 && blockLines[len(blockLines)-1] == "" {
+		blockLines = blockLines[:len(blockLines)-1]
+	}
+
+	var result string
+	if strings.HasPrefix(style, ">") {
+		var builder strings.Builder
+		for i, line := range blockLines {
+			switch {
+			case i == 0 || blockLines[i-1] == "":
+			case line == "":
+				builder.WriteString("\n")
+			default:
+				builder.WriteString(" ")
+			}
+			builder.WriteString(line)
+		}
+		result = builder.String()
+	} else {
+		result = strings.Join(blockLines, "\n")
+	}
+	if !strings.HasSuffix(style, "-") && result != "" {
+		result += "\n"
+	}
+	return result, nil
+}
+
+func (p *yamlParser) parseFlowSequence(text string) ([]any, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, p.valueErrorf("list is missing the closing \"]\"")
+	}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	result := make([]any, 0)
+	if inner == "" {
+		return result, nil
+	}
+	for _, entry := range SplitFlowEntries(inner) {
+		value, err := p.parseScalar(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+func (p *yamlParser) parseScalar(text string) (any, error) {
+	if text == "" {
+		return nil, nil
+	}
+	switch text[0] {
+	case '"':
+		if len(text) < 2 || !strings.HasSuffix(text, "\"") {
+			return nil, p.valueErrorf("string %s is missing the closing quote", text)
+		}
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, p.valueErrorf("invalid string %s", text)
+		}
+		return value, nil
+	case '\'':
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, p.valueErrorf("string %s is missing the closing quote", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case '{':
+		return nil, p.valueErrorf("inline mappings are not supported")
+	}
+
+	switch strings.ToLower(text) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	case "null", "~":
+		return nil, nil
+	}
+	if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return number, nil
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil {
+		return number, nil
+	}
+	return text, nil
+}
+
+// CutYAMLKey splits "key: value" at the first colon outside of quotes that is followed by a space
+func CutYAMLKey(text string) (key string, rest string, found bool) {
+	if text == "" || text[0] == '"' || text[0] == '\'' || text[0] == '[' {
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), i > 0
+		}
+		if text[i] == ' ' && i+1 < len(text) && text[i+1] == '#' {
+			return "", "", false
+		}
+	}
+	return "", "", false
+}
+
+// StripYAMLComment removes a trailing " # comment" that is not inside quotes
+func StripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quote != 0:
+			if text[i] == quote {
+				quote = 0
+			}
+		case text[i] == '"' || text[i] == '\'':
+			quote = text[i]
+		case text[i] == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimSpace(text[:i])
+		}
+	}
+	return text
+}
+
+// SplitFlowEntries splits the inside of a "[a, 'b, c']" list at commas outside of quotes
+func SplitFlowEntries(text string) []string {
+	entries := make([]string, 0)
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quote != 0:
+			if text[i] == quote {
+				quote = 0
+			}
+		case text[i] == '"' || text[i] == '\'':
+			quote = text[i]
+		case text[i] == ',':
+			entries = append(entries, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(entries, text[start:])
+}
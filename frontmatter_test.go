@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want map[string]any
+	}{
+		{
+			name: "scalars",
+			yaml: "title: Hello World\nquoted: \"a: b\"\nsingle: 'it''s'\ncount: 3\nratio: 1.5\ndraft: true\nempty:\n",
+			want: map[string]any{
+				"title":  "Hello World",
+				"quoted": "a: b",
+				"single": "it's",
+				"count":  int64(3),
+				"ratio":  1.5,
+				"draft":  true,
+				"empty":  nil,
+			},
+		},
+		{
+			name: "comments",
+			yaml: "# leading comment\ntitle: Hello # trailing comment\n",
+			want: map[string]any{"title": "Hello"},
+		},
+		{
+			name: "lists",
+			yaml: "tags:\n  - go\n  - markdown\nflow: [a, \"b, c\", 3]\nsame:\n- x\n- y\n",
+			want: map[string]any{
+				"tags": []any{"go", "markdown"},
+				"flow": []any{"a", "b, c", int64(3)},
+				"same": []any{"x", "y"},
+			},
+		},
+		{
+			name: "nested",
+			yaml: "author:\n  name: Jane\n  links:\n    - name: site\n      url: https://example.com\n",
+			want: map[string]any{
+				"author": map[string]any{
+					"name": "Jane",
+					"links": []any{
+						map[string]any{"name": "site", "url": "https://example.com"},
+					},
+				},
+			},
+		},
+		{
+			name: "literal block",
+			yaml: "desc: |\n  first\n    indented\n\n  last\nnext: value\n",
+			want: map[string]any{"desc": "first\n  indented\n\nlast\n", "next": "value"},
+		},
+		{
+			name: "folded block",
+			yaml: "desc: >-\n  one\n  two\n\n  three\n",
+			want: map[string]any{"desc": "one two\nthree"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseYAML([]byte(test.yaml))
+			if err != nil {
+				t.Fatalf("ParseYAML() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseYAML() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		line int
+	}{
+		{name: "tab indentation", yaml: "author:\n\tname: Jane\n", line: 2},
+		{name: "unexpected indentation", yaml: "title: a\n  other: b\n", line: 2},
+		{name: "block dedent", yaml: "desc: |\n    first\n  second\n", line: 3},
+		{name: "duplicate key", yaml: "title: a\ntitle: b\n", line: 2},
+		{name: "missing key", yaml: "title: a\njust text\n", line: 2},
+		{name: "unclosed list", yaml: "tags: [a, b\n", line: 1},
+		{name: "unclosed quote", yaml: "title: \"hello\n", line: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseYAML([]byte(test.yaml))
+			var yamlErr *FrontMatterError
+			if !errors.As(err, &yamlErr) {
+				t.Fatalf("ParseYAML() error = %v, want a FrontMatterError", err)
+			}
+			if yamlErr.Line != test.line {
+				t.Errorf("ParseYAML() error on line %d, want line %d: %v", yamlErr.Line, test.line, err)
+			}
+		})
+	}
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		frontMatter string
+		body        string
+	}{
+		{name: "none", text: "# Title\n", body: "# Title\n"},
+		{name: "closed", text: "---\ntitle: a\n---\n# Title\n", frontMatter: "title: a\n", body: "# Title\n"},
+		{name: "dots", text: "---\ntitle: a\n...\nbody", frontMatter: "title: a\n", body: "body"},
+		{name: "byte order mark", text: "\xef\xbb\xbf---\ntitle: a\n---\n", frontMatter: "title: a\n"},
+		{name: "unclosed rule", text: "---\n\nSome text after a rule\n", body: "---\n\nSome text after a rule\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frontMatter, body := SplitFrontMatter([]byte(test.text))
+			if string(frontMatter) != test.frontMatter || string(body) != test.body {
+				t.Errorf("SplitFrontMatter() = %q, %q, want %q, %q", frontMatter, body, test.frontMatter, test.body)
+			}
+		})
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	meta, body, err := ParseFrontMatter([]byte("---\ntitle: Hello\ntags: [a, b]\nextra: 1\n---\nbody"))
+	if err != nil {
+		t.Fatalf("ParseFrontMatter() error = %v", err)
+	}
+	if meta.Title != "Hello" || !reflect.DeepEqual(meta.Tags, []string{"a", "b"}) || meta.Custom["extra"] != int64(1) {
+		t.Errorf("ParseFrontMatter() meta = %+v", meta)
+	}
+	if string(body) != "body" {
+		t.Errorf("ParseFrontMatter() body = %q, want %q", body, "body")
+	}
+
+	_, _, err = ParseFrontMatter([]byte("---\ndesc: |\n    first\n  second\n---\n"))
+	var yamlErr *FrontMatterError
+	if !errors.As(err, &yamlErr) || yamlErr.Line != 4 {
+		t.Errorf("ParseFrontMatter() error = %v, want a FrontMatterError on line 4", err)
+	}
+}
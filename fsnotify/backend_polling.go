@@ -0,0 +1,262 @@
+//go:build !windows && (!linux || appengine)
+
+// Polling backend for platforms without an event based backend.
+//
+// Every watched path is read again after pollInterval and compared with the
+// previous snapshot. Renames can't be paired, they're sent as a Remove of the
+// old name followed by a Create of the new one.
+
+package fsnotify
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// pollInterval is how often the watched paths are compared with their snapshots.
+const pollInterval = 500 * time.Millisecond
+
+var defaultBufferSize = 0
+
+type polling struct {
+	*shared
+	watches  map[string]*pollWatch // Watched path → snapshot
+	doneResp chan struct{}         // Channel to respond to Close
+}
+
+type pollWatch struct {
+	op    Op
+	isDir bool
+	// Name (the path itself for a file watch) → state during the last poll
+	entries map[string]pollState
+}
+
+type pollState struct {
+	modTime time.Time
+	size    int64
+	mode    fs.FileMode
+}
+
+func newBackend(ev chan Event, errs chan error) (backend, error) {
+	w := &polling{
+		shared:   newShared(ev, errs),
+		watches:  make(map[string]*pollWatch),
+		doneResp: make(chan struct{}),
+	}
+	go w.poll()
+	return w, nil
+}
+
+func (w *polling) Close() error {
+	if w.shared.close() {
+		return nil
+	}
+	<-w.doneResp // Wait for poll() to finish.
+	return nil
+}
+
+func (w *polling) Add(name string) error { return w.AddWith(name) }
+
+func (w *polling) AddWith(name string, opts ...addOpt) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	if debug {
+		fmt.Fprintf(os.Stderr, "FSNOTIFY_DEBUG: %s  AddWith(%q)\n",
+			time.Now().Format("15:04:05.000000000"), name)
+	}
+
+	with := getOptions(opts...)
+	if !w.xSupports(with.op) {
+		return fmt.Errorf("%w: %s", xErrUnsupported, with.op)
+	}
+
+	name = filepath.Clean(name)
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	entries, err := snapshot(name, info)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watches[name] = &pollWatch{op: with.op, isDir: info.IsDir(), entries: entries}
+	return nil
+}
+
+func (w *polling) Remove(name string) error {
+	if w.isClosed() {
+		return nil
+	}
+	if debug {
+		fmt.Fprintf(os.Stderr, "FSNOTIFY_DEBUG: %s  Remove(%q)\n",
+			time.Now().Format("15:04:05.000000000"), name)
+	}
+
+	name = filepath.Clean(name)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.watches[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
+	}
+	delete(w.watches, name)
+	return nil
+}
+
+func (w *polling) WatchList() []string {
+	if w.isClosed() {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entries := make([]string, 0, len(w.watches))
+	for pathname := range w.watches {
+		entries = append(entries, pathname)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func (w *polling) xSupports(op Op) bool {
+	if op.Has(xUnportableOpen) || op.Has(xUnportableRead) ||
+		op.Has(xUnportableCloseWrite) || op.Has(xUnportableCloseRead) {
+		return false
+	}
+	return true
+}
+
+// poll compares the watched paths with their snapshots until the watcher is
+// closed.
+func (w *polling) poll() {
+	defer close(w.doneResp)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		for _, e := range w.changes() {
+			if debug {
+				fmt.Fprintf(os.Stderr, "FSNOTIFY_DEBUG: %s  %s\n",
+					time.Now().Format("15:04:05.000000000"), e)
+			}
+			if !w.sendEvent(e) {
+				return
+			}
+		}
+	}
+}
+
+// changes reads every watched path again and returns the events since the
+// last poll. A watched path that is gone is removed from the watches, like the
+// other backends do.
+func (w *polling) changes() []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := make([]Event, 0)
+	for _, name := range sortedKeys(w.watches) {
+		watch := w.watches[name]
+		info, err := os.Stat(name)
+		var entries map[string]pollState
+		if err == nil && info.IsDir() == watch.isDir {
+			entries, err = snapshot(name, info)
+		}
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) {
+				// Try again on the next poll.
+				continue
+			}
+			if watch.isDir {
+				for _, child := range sortedKeys(watch.entries) {
+					events = appendEvent(events, watch.op, Event{Name: filepath.Join(name, child), Op: Remove})
+				}
+			}
+			events = appendEvent(events, watch.op, Event{Name: name, Op: Remove})
+			delete(w.watches, name)
+			continue
+		}
+
+		for _, child := range sortedKeys(watch.entries) {
+			if _, ok := entries[child]; !ok {
+				events = appendEvent(events, watch.op, Event{Name: pollPath(name, child, watch.isDir), Op: Remove})
+			}
+		}
+		for _, child := range sortedKeys(entries) {
+			now := entries[child]
+			before, ok := watch.entries[child]
+			path := pollPath(name, child, watch.isDir)
+			switch {
+			case !ok:
+				events = appendEvent(events, watch.op, Event{Name: path, Op: Create})
+			case !now.modTime.Equal(before.modTime) || now.size != before.size:
+				events = appendEvent(events, watch.op, Event{Name: path, Op: Write})
+			case now.mode != before.mode:
+				events = appendEvent(events, watch.op, Event{Name: path, Op: Chmod})
+			}
+		}
+		watch.entries = entries
+	}
+	return events
+}
+
+// snapshot returns the state of the entries of a directory, or of the file
+// itself.
+func snapshot(name string, info fs.FileInfo) (map[string]pollState, error) {
+	if !info.IsDir() {
+		return map[string]pollState{
+			filepath.Base(name): {modTime: info.ModTime(), size: info.Size(), mode: info.Mode()},
+		}, nil
+	}
+
+	dirEntries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]pollState, len(dirEntries))
+	for _, entry := range dirEntries {
+		entryInfo, err := entry.Info()
+		if err != nil {
+			// Removed between reading the directory and its entry; it's
+			// reported on the next poll.
+			continue
+		}
+		entries[entry.Name()] = pollState{modTime: entryInfo.ModTime(), size: entryInfo.Size(), mode: entryInfo.Mode()}
+	}
+	return entries, nil
+}
+
+func pollPath(name, child string, isDir bool) string {
+	if isDir {
+		return filepath.Join(name, child)
+	}
+	return name
+}
+
+func appendEvent(events []Event, op Op, e Event) []Event {
+	if !op.Has(e.Op) {
+		return events
+	}
+	return append(events, e)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func Debug(name string, mask, cookie uint32) {
+	names := []struct {
+		n string
+		m uint32
+	}{
+		{"IN_ACCESS", syscall.IN_ACCESS},
+		{"IN_ATTRIB", syscall.IN_ATTRIB},
+		{"IN_CLOSE_NOWRITE", syscall.IN_CLOSE_NOWRITE},
+		{"IN_CLOSE_WRITE", syscall.IN_CLOSE_WRITE},
+		{"IN_CREATE", syscall.IN_CREATE},
+		{"IN_DELETE", syscall.IN_DELETE},
+		{"IN_DELETE_SELF", syscall.IN_DELETE_SELF},
+		{"IN_IGNORED", syscall.IN_IGNORED},
+		{"IN_ISDIR", syscall.IN_ISDIR},
+		{"IN_MODIFY", syscall.IN_MODIFY},
+		{"IN_MOVED_FROM", syscall.IN_MOVED_FROM},
+		{"IN_MOVED_TO", syscall.IN_MOVED_TO},
+		{"IN_MOVE_SELF", syscall.IN_MOVE_SELF},
+		{"IN_OPEN", syscall.IN_OPEN},
+		{"IN_Q_OVERFLOW", syscall.IN_Q_OVERFLOW},
+		{"IN_UNMOUNT", syscall.IN_UNMOUNT},
+	}
+
+	var (
+		l       []string
+		unknown = mask
+	)
+	for _, n := range names {
+		if mask&n.m == n.m {
+			l = append(l, n.n)
+			unknown ^= n.m
+		}
+	}
+	if unknown > 0 {
+		l = append(l, fmt.Sprintf("0x%x", unknown))
+	}
+	var c string
+	if cookie > 0 {
+		c = fmt.Sprintf("(cookie: %d) ", cookie)
+	}
+	fmt.Fprintf(os.Stderr, "FSNOTIFY_DEBUG: %s  %-30s → %s%q\n",
+		time.Now().Format("15:04:05.000000000"), strings.Join(l, "|"), c, name)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"markdown-server/chroma"
+	format "markdown-server/chroma/formatters/html"
+	"markdown-server/chroma/lexers"
+)
+
+/*************************************************
+*** FUNCTIONS FOR THE HIGHLIGHTING STYLESHEET ***
+**************************************************/
+
+// StylesheetPrefix starts the names of the generated highlighting stylesheets
+const StylesheetPrefix = "chroma-"
+
+// stylesheetFormatter writes the rules for every class the page formatters can use, including line numbers
+var stylesheetFormatter = format.New(format.WithClasses(true), format.WithLineNumbers(true))
+
+// Stylesheet returns the name of the generated stylesheet for the highlight settings,
+// or an empty string if the code is highlighted with inline styles
+func (page *PageConfig) Stylesheet() string {
+	if page.Highlight.InlineStyles {
+		return ""
+	}
+	name := StylesheetPrefix + Slugify(page.Highlight.Style)
+	if page.Highlight.DarkStyle != "" {
+		name += "-" + Slugify(page.Highlight.DarkStyle)
+	}
+	return name + ".css"
+}
+
+// StylesheetCSS generates the rules of the style, followed by the rules of the dark style
+// for browsers that prefer a dark color scheme
+func (page *PageConfig) StylesheetCSS() ([]byte, error) {
+	var css bytes.Buffer
+	err := stylesheetFormatter.WriteCSS(&css, page.style)
+	if err != nil || page.darkStyle == nil {
+		return css.Bytes(), err
+	}
+	css.WriteString("@media (prefers-color-scheme: dark) {\n")
+	err = stylesheetFormatter.WriteCSS(&css, page.darkStyle)
+	css.WriteString("}\n")
+	return css.Bytes(), err
+}
+
+// Stylesheets returns the settings for every generated stylesheet of the config by name
+func (config *Config) Stylesheets() map[string]*PageConfig {
+	result := make(map[string]*PageConfig)
+	pages := []*PageConfig{&config.Page}
+	for _, directory := range SortedKeys(config.Directories) {
+		settings := config.Directories[directory]
+		pages = append(pages, &settings)
+	}
+	for _, page := range pages {
+		if name := page.Stylesheet(); name != "" && result[name] == nil {
+			result[name] = page
+		}
+	}
+	return result
+}
+
+// GeneratedStylesheet returns the settings for a stylesheet relative to the markdown folder,
+// or nil if it is not generated. A file of the same name in the markdown folder takes precedence.
+func GeneratedStylesheet(relative string) *PageConfig {
+	page := GetConfig().Stylesheets()[relative]
+	if page == nil || fileExists(SourcePath(relative)) {
+		return nil
+	}
+	return page
+}
+
+// WriteHighlightStylesheets writes the generated stylesheets into the root of the target folder
+func WriteHighlightStylesheets() error {
+	for name := range GetConfig().Stylesheets() {
+		page := GeneratedStylesheet(name)
+		if page == nil {
+			continue
+		}
+		css, err := page.StylesheetCSS()
+		if err != nil {
+			return err
+		}
+		err = WriteTarget(filepath.Join(TargetFolder, name), css)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PageStylesheets returns the stylesheets linked in a page. The highlighting stylesheet comes first,
+// so the stylesheets of the markdown folder can override its rules.
+func PageStylesheets(settings *PageConfig) []string {
+	name := settings.Stylesheet()
+	if name == "" || slices.Contains(CSSFileList, name) {
+		return CSSFileList
+	}
+	return append([]string{name}, CSSFileList...)
+}
+
+/*******************************************
+*** FUNCTIONS FOR CODE BLOCK ATTRIBUTES ***
+********************************************/
+
+// CodeInfo is the info string of a fenced code block, like `go {hl_lines=[3,5-7] linenostart=10 title="main.go"}`
+type CodeInfo struct {
+	Language string
+	// HighlightLines are inclusive ranges of lines counted from the first line of the block
+	HighlightLines [][2]int
+	// LineNumberStart is the number of the first line, zero if it is not given
+	LineNumberStart int
+	// LineNumbers overrides highlight.line_numbers of the settings if it is not nil
+	LineNumbers *bool
+	Title       string
+}
+
+// ParseCodeInfo reads the language and the attributes of an info string. The attributes can be written in
+// braces after the language or without them, values are bare words, quoted strings or lists in brackets.
+func ParseCodeInfo(text string) (CodeInfo, error) {
+	var info CodeInfo
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") && strings.HasSuffix(text, "}") {
+		text = text[1 : len(text)-1]
+	}
+	end := strings.IndexAny(text, " \t{")
+	if end == -1 {
+		end = len(text)
+	}
+	if !strings.Contains(text[:end], "=") {
+		info.Language, text = text[:end], text[end:]
+	}
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		if !strings.HasSuffix(text, "}") {
+			return info, fmt.Errorf("missing \"}\" after the attributes %q", text)
+		}
+		text = text[1 : len(text)-1]
+	}
+
+	for text = strings.TrimSpace(text); text != ""; text = strings.TrimLeft(text, " \t,") {
+		key, rest, found := strings.Cut(text, "=")
+		if !found || strings.ContainsAny(key, " \t,") {
+			return info, fmt.Errorf("attribute %q has no value", strings.Fields(text)[0])
+		}
+		key = strings.TrimSpace(key)
+		value, rest, err := cutAttributeValue(strings.TrimSpace(rest))
+		if err != nil {
+			return info, fmt.Errorf("attribute %q: %w", key, err)
+		}
+		text = rest
+
+		switch key {
+		case "hl_lines":
+			info.HighlightLines, err = ParseLineRanges(value)
+		case "linenostart":
+			info.LineNumberStart, err = strconv.Atoi(value)
+			if err == nil && info.LineNumberStart < 0 {
+				err = fmt.Errorf("%d is negative", info.LineNumberStart)
+			}
+		case "linenos":
+			var enabled bool
+			enabled, err = strconv.ParseBool(value)
+			info.LineNumbers = &enabled
+		case "title":
+			info.Title = value
+		default:
+			err = fmt.Errorf("unknown attribute, known are hl_lines, linenostart, linenos and title")
+		}
+		if err != nil {
+			return info, fmt.Errorf("attribute %q: %w", key, err)
+		}
+	}
+	return info, nil
+}
+
+// cutAttributeValue splits a quoted string, a list in brackets or a bare word from the start of the text
+func cutAttributeValue(text string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		end := strings.IndexByte(text[1:], '"')
+		if end == -1 {
+			return "", "", fmt.Errorf("missing closing quote")
+		}
+		return text[1 : end+1], text[end+2:], nil
+	case strings.HasPrefix(text, "["):
+		end := strings.IndexByte(text, ']')
+		if end == -1 {
+			return "", "", fmt.Errorf("missing \"]\"")
+		}
+		return text[1:end], text[end+1:], nil
+	}
+	end := strings.IndexAny(text, " \t,")
+	if end == -1 {
+		end = len(text)
+	}
+	if end == 0 {
+		return "", "", fmt.Errorf("missing value")
+	}
+	return text[:end], text[end:], nil
+}
+
+// ParseLineRanges reads line numbers and ranges separated by commas or spaces, like "3,5-7" or "3 5-7"
+func ParseLineRanges(text string) ([][2]int, error) {
+	ranges := make([][2]int, 0)
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		first, last, isRange := strings.Cut(field, "-")
+		if !isRange {
+			last = first
+		}
+		start, errStart := strconv.Atoi(strings.TrimSpace(first))
+		end, errEnd := strconv.Atoi(strings.TrimSpace(last))
+		if errStart != nil || errEnd != nil || start < 1 || start > end {
+			return nil, fmt.Errorf("%q is not a line or range of lines like 5-7", field)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+// HasOptions reports if the code block needs a formatter of its own
+func (info CodeInfo) HasOptions() bool {
+	return len(info.HighlightLines) != 0 || info.LineNumberStart != 0 || info.LineNumbers != nil
+}
+
+// Formatter returns the formatter of the settings with the attributes of the code block applied.
+// Line numbers get ids starting with the prefix, so they can be linked.
+func (page *PageConfig) Formatter(info CodeInfo, prefix string) *format.Formatter {
+	if !info.HasOptions() {
+		return page.formatter
+	}
+	lineNumbers := page.Highlight.LineNumbers
+	if info.LineNumbers != nil {
+		lineNumbers = *info.LineNumbers
+	}
+	start := max(info.LineNumberStart, 1)
+	// the highlighted lines are counted from the start of the block, chroma counts them like the line numbers
+	highlight := make([][2]int, len(info.HighlightLines))
+	for i, lines := range info.HighlightLines {
+		highlight[i] = [2]int{lines[0] + start - 1, lines[1] + start - 1}
+	}
+	return format.New(
+		format.WithClasses(!page.Highlight.InlineStyles),
+		format.Standalone(false),
+		format.WithLineNumbers(lineNumbers),
+		format.WithLinkableLineNumbers(lineNumbers, prefix),
+		format.TabWidth(page.Highlight.TabWidth),
+		format.BaseLineNumber(start),
+		format.HighlightLines(highlight),
+	)
+}
+
+/*******************************************
+*** FUNCTIONS FOR LANGUAGE DETECTION ***
+********************************************/
+
+// Lexer returns the lexer for the language of the code block. If the language is missing or unknown and
+// highlight.detect_language is set, the lexer is guessed from the file name in the title and then from the code.
+// The result is nil if no lexer was found, detected reports if the lexer was guessed.
+func (page *PageConfig) Lexer(info CodeInfo, source []byte) (lexer chroma.Lexer, detected bool) {
+	if info.Language != "" {
+		if lexer = lexers.Get(info.Language); lexer != nil {
+			return lexer, false
+		}
+	}
+	if !page.Highlight.DetectLanguage {
+		return nil, false
+	}
+	if info.Title != "" {
+		lexer = lexers.Match(filepath.Base(info.Title))
+	}
+	if lexer == nil {
+		lexer = lexers.Analyse(string(source))
+	}
+	return lexer, lexer != nil
+}
+
+// LexerName returns the first alias of the lexer, or its name in lower case if it has none
+func LexerName(lexer chroma.Lexer) string {
+	config := lexer.Config()
+	if len(config.Aliases) != 0 {
+		return config.Aliases[0]
+	}
+	return strings.ToLower(config.Name)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCodeInfo(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name string
+		info string
+		want CodeInfo
+	}{
+		{name: "empty", info: "", want: CodeInfo{}},
+		{name: "language", info: "go", want: CodeInfo{Language: "go"}},
+		{
+			name: "braces",
+			info: "go {hl_lines=[1,3-4] linenostart=10}",
+			want: CodeInfo{Language: "go", HighlightLines: [][2]int{{1, 1}, {3, 4}}, LineNumberStart: 10},
+		},
+		{
+			name: "without braces",
+			info: `python hl_lines="2 4" title="main file", linenos=true`,
+			want: CodeInfo{Language: "python", HighlightLines: [][2]int{{2, 2}, {4, 4}}, Title: "main file", LineNumbers: &enabled},
+		},
+		{name: "outer braces", info: "{go linenos=false}", want: CodeInfo{Language: "go", LineNumbers: &disabled}},
+		{name: "no language", info: "{linenos=false}", want: CodeInfo{LineNumbers: &disabled}},
+		{name: "no language without braces", info: "title=main.go", want: CodeInfo{Title: "main.go"}},
+		{name: "brace after language", info: "go{title=x}", want: CodeInfo{Language: "go", Title: "x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseCodeInfo(test.info)
+			if err != nil {
+				t.Fatalf("ParseCodeInfo(%q) error = %v", test.info, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseCodeInfo(%q) = %+v, want %+v", test.info, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCodeInfoErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+	}{
+		{name: "unclosed braces", info: "go {hl_lines=2"},
+		{name: "no value", info: "go hl_lines"},
+		{name: "empty value", info: "go title="},
+		{name: "unknown attribute", info: "go color=red"},
+		{name: "unclosed quote", info: `go title="main`},
+		{name: "unclosed list", info: "go hl_lines=[1,2"},
+		{name: "bad line range", info: "go hl_lines=3-1"},
+		{name: "negative start", info: "go linenostart=-1"},
+		{name: "not a number", info: "go linenostart=ten"},
+		{name: "not a bool", info: "go linenos=maybe"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseCodeInfo(test.info); err == nil {
+				t.Errorf("ParseCodeInfo(%q) error = nil, want an error", test.info)
+			}
+		})
+	}
+}
+
+func TestParseLineRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want [][2]int
+	}{
+		{name: "empty", text: "", want: [][2]int{}},
+		{name: "single line", text: "3", want: [][2]int{{3, 3}}},
+		{name: "commas", text: "3,5-7", want: [][2]int{{3, 3}, {5, 7}}},
+		{name: "spaces", text: "3 5-7", want: [][2]int{{3, 3}, {5, 7}}},
+		{name: "mixed separators", text: " 1, 2-2 ,4 ", want: [][2]int{{1, 1}, {2, 2}, {4, 4}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseLineRanges(test.text)
+			if err != nil {
+				t.Fatalf("ParseLineRanges(%q) error = %v", test.text, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseLineRanges(%q) = %v, want %v", test.text, got, test.want)
+			}
+		})
+	}
+
+	for _, text := range []string{"a", "0", "5-3", "1-", "-2", "1-2-3"} {
+		if _, err := ParseLineRanges(text); err == nil {
+			t.Errorf("ParseLineRanges(%q) error = nil, want an error", text)
+		}
+	}
+}
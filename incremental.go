@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/****************************************************
+*** FUNCTIONS FOR TRACKING DEPENDENCIES OF PAGES ***
+*****************************************************/
+
+// CSSListDependency is the input representing the list of stylesheets linked in every page
+const CSSListDependency = "<css list>"
+
+// LinkDependency returns the input representing the existence of a link destination. Pages only
+// depend on whether the destination exists, so writing to it does not rebuild the linking pages.
+func LinkDependency(source string) string {
+	return "<link> " + source
+}
+
+// Graph is the dependency graph of the current build, it is replaced on every full rebuild
+var Graph = NewDependencyGraph()
+
+// DependencyGraph records which pages depend on which inputs. Pages and file inputs
+// are identified by their absolute source path.
+type DependencyGraph struct {
+	mu sync.Mutex
+	// page → inputs the page was generated from
+	dependencies map[string][]string
+	// input → pages generated from it
+	dependents map[string]map[string]bool
+}
+
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{
+		dependencies: make(map[string][]string),
+		dependents:   make(map[string]map[string]bool),
+	}
+}
+
+// SetDependencies replaces all inputs recorded for the page
+func (g *DependencyGraph) SetDependencies(page string, inputs []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removePage(page)
+	g.dependencies[page] = inputs
+	for _, input := range inputs {
+		if g.dependents[input] == nil {
+			g.dependents[input] = make(map[string]bool)
+		}
+		g.dependents[input][page] = true
+	}
+}
+
+func (g *DependencyGraph) RemovePage(page string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removePage(page)
+}
+
+func (g *DependencyGraph) removePage(page string) {
+	for _, input := range g.dependencies[page] {
+		delete(g.dependents[input], page)
+		if len(g.dependents[input]) == 0 {
+			delete(g.dependents, input)
+		}
+	}
+	delete(g.dependencies, page)
+}
+
+// Dependents returns the sorted list of pages generated from the input
+func (g *DependencyGraph) Dependents(input string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return SortedKeys(g.dependents[input])
+}
+
+// DependentsInside returns the sorted list of pages generated from any input inside the directory
+func (g *DependencyGraph) DependentsInside(directory string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	pages := make(map[string]bool)
+	for input, dependents := range g.dependents {
+		input = strings.TrimPrefix(input, LinkDependency(""))
+		if strings.HasPrefix(input, directory+string(filepath.Separator)) {
+			for page := range dependents {
+				pages[page] = true
+			}
+		}
+	}
+	return SortedKeys(pages)
+}
+
+// Pages returns the sorted list of pages inside the source directory, or all pages if it is empty
+func (g *DependencyGraph) Pages(directory string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	result := make([]string, 0)
+	for page := range g.dependencies {
+		if directory == "" || strings.HasPrefix(page, directory+string(filepath.Separator)) {
+			result = append(result, page)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+/*****************************************
+*** FUNCTIONS FOR INCLUDING FILES ***
+******************************************/
+
+// IncludeReader resolves "{{path}}" includes relative to the file containing them
+// and remembers every included file as a dependency of the page. Files outside the markdown folder
+// are never included.
+type IncludeReader struct {
+	source string
+	// include path as written → absolute path, used to resolve nested includes
+	resolved map[string]string
+	// include path as written → include path of the file containing it
+	parents map[string]string
+	Files   []string
+	Err     error
+}
+
+func NewIncludeReader(source string) *IncludeReader {
+	return &IncludeReader{
+		source:   source,
+		resolved: make(map[string]string),
+		parents:  make(map[string]string),
+	}
+}
+
+// Read implements parser.ReadIncludeFunc, line addresses are not supported and the whole file is included
+func (r *IncludeReader) Read(from, path string, _ []byte) []byte {
+	file := path
+	if !filepath.IsAbs(file) {
+		directory := filepath.Dir(r.source)
+		if parent, ok := r.resolved[from]; ok {
+			directory = filepath.Dir(parent)
+		}
+		file = filepath.Join(directory, path)
+	}
+	relative, err := SourceRelative(file)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		r.addError(fmt.Errorf("include %q: file is outside of the markdown folder", path))
+		return nil
+	}
+
+	// a file including itself, directly or indirectly, would never terminate
+	if file == r.source {
+		r.addError(fmt.Errorf("include %q: page includes itself", path))
+		return nil
+	}
+	for ancestor := from; ancestor != ""; ancestor = r.parents[ancestor] {
+		if r.resolved[ancestor] == file {
+			r.addError(fmt.Errorf("include %q: include cycle", path))
+			return nil
+		}
+	}
+
+	r.resolved[path] = file
+	r.parents[path] = from
+	if !slices.Contains(r.Files, file) {
+		r.Files = append(r.Files, file)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		r.addError(fmt.Errorf("include %q: %w", path, err))
+		return nil
+	}
+	return data
+}
+
+func (r *IncludeReader) addError(err error) {
+	r.Err = errors.Join(r.Err, err)
+}
+
+/*********************************************
+*** FUNCTIONS FOR INCREMENTAL REBUILDS ***
+**********************************************/
+
+// RebuildSource brings the target folder up to date after the source path was created,
+// written, renamed or removed. Only the target of the path and the pages depending on it are touched.
+// Update is true if the path was only written to, so pages linking to it stay untouched.
+func RebuildSource(path string, update bool) (err error) {
+	relative, err := SourceRelative(path)
+	if err != nil {
+		return err
+	}
+	if relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	if IsLayoutSource(relative) {
+		return errors.Join(RebuildLayout(SourcePath(relative)), BuildGeneratedPages())
+	}
+	if IsConfigSource(relative) {
+		return RebuildConfig()
+	}
+
+	// a changed title, weight or page changes the navigation of every page
+	if SiteNavigation.Refresh(relative) {
+		defer func() {
+			err = errors.Join(err, RebuildDependents(NavigationDependency), BuildGeneratedPages())
+		}()
+	}
+
+	info, err := os.Stat(SourcePath(relative))
+	if errors.Is(err, fs.ErrNotExist) {
+		return RemoveSource(relative)
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return BuildDirectory(relative)
+	}
+
+	errs := []error{BuildSourceFile(relative)}
+	if IsCSSListEntry(relative) && !slices.Contains(CSSFileList, info.Name()) {
+		CSSFileList = append(CSSFileList, info.Name())
+		sort.Strings(CSSFileList)
+		errs = append(errs, RebuildDependents(CSSListDependency), BuildGeneratedPages())
+	}
+	errs = append(errs, RebuildDependents(SourcePath(relative)))
+	if !update {
+		errs = append(errs, RebuildDependents(LinkDependency(SourcePath(relative))))
+	}
+	return errors.Join(errs...)
+}
+
+// BuildDirectory creates the target of a new source directory with all of its content
+func BuildDirectory(relative string) error {
+	errs := make([]error, 0)
+	err := WalkSources(SourcePath(relative), func(fileRelative string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return MakeTargetFolder(TargetPath(fileRelative))
+		}
+		errs = append(errs, BuildSourceFile(fileRelative))
+		return nil
+	})
+	errs = append(errs, err, RebuildDependents(LinkDependency(SourcePath(relative))))
+	errs = append(errs, RebuildPages(Graph.DependentsInside(SourcePath(relative))))
+	return errors.Join(errs...)
+}
+
+// RemoveSource deletes the target of a removed source file or directory
+func RemoveSource(relative string) error {
+	source := SourcePath(relative)
+	errs := []error{RemoveTarget(TargetPath(relative))}
+	if PrettyURLs && strings.HasSuffix(relative, ".md") {
+		// the folder of a pretty page is only removed if nothing else lives in it
+		RemoveEmptyTarget(filepath.Dir(TargetPath(relative)))
+	}
+
+	Graph.RemovePage(source)
+	for _, page := range Graph.Pages(source) {
+		Graph.RemovePage(page)
+	}
+	SiteLinks.Remove(relative)
+	SiteWarnings.Remove(relative)
+	SiteSearch.Remove(relative)
+
+	if index := slices.Index(CSSFileList, filepath.Base(relative)); IsCSSListEntry(relative) && index != -1 {
+		CSSFileList = slices.Delete(CSSFileList, index, index+1)
+		errs = append(errs, RebuildDependents(CSSListDependency), BuildGeneratedPages())
+	}
+	pages := slices.Concat(Graph.Dependents(source), Graph.Dependents(LinkDependency(source)), Graph.DependentsInside(source))
+	slices.Sort(pages)
+	errs = append(errs, RebuildPages(slices.Compact(pages)))
+	return errors.Join(errs...)
+}
+
+// RebuildLayout regenerates the pages using a changed layout. Layouts can include each other,
+// so a change to a file no page uses directly regenerates every page.
+func RebuildLayout(source string) error {
+	ResetLayouts()
+	pages := Graph.Dependents(source)
+	if len(pages) == 0 {
+		pages = Graph.Pages("")
+	}
+	return RebuildPages(pages)
+}
+
+// RebuildConfig loads the changed config file and regenerates every page with the new settings
+func RebuildConfig() error {
+	if err := ReloadConfig(); err != nil {
+		return err
+	}
+	return errors.Join(RebuildPages(Graph.Pages("")), BuildGeneratedPages())
+}
+
+func RebuildDependents(input string) error {
+	return RebuildPages(Graph.Dependents(input))
+}
+
+func RebuildPages(pages []string) error {
+	relatives := make([]string, 0, len(pages))
+	for _, page := range pages {
+		relative, err := SourceRelative(page)
+		if err != nil {
+			return err
+		}
+		relatives = append(relatives, relative)
+	}
+	_, err := BuildFiles(relatives)
+	return err
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*********************************************
+*** FUNCTIONS FOR RENDERING PAGE LAYOUTS ***
+**********************************************/
+
+// LayoutFolder is the folder in the markdown root containing the html/template layouts.
+// A page selects "<layout>.html" via the front matter key "layout", otherwise "default.html" is used.
+const LayoutFolder = "_layouts"
+
+const DefaultLayoutName = "default"
+
+// PageData is the data every layout is executed with
+type PageData struct {
+	Title      string
+	Meta       PageMeta
+	Body       template.HTML
+	CSSFiles   []string
+	Navigation template.HTML
+	TOC        template.HTML
+	// Scripts are loaded at the end of the body, like the renderers of diagrams
+	Scripts []Script
+}
+
+// Script is a script loaded by a page. Scripts from other servers should set the integrity,
+// so the browser refuses them if they change.
+type Script struct {
+	Src       string
+	Integrity string
+}
+
+var BuiltinLayout = template.Must(template.New(DefaultLayoutName + ".html").Parse(`<!DOCTYPE html>
+<html lang="{{.Meta.Lang}}">
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+{{- with .Meta.Description}}
+<meta name="description" content="{{.}}">
+{{- end}}
+{{- with .Meta.Author}}
+<meta name="author" content="{{.}}">
+{{- end}}
+{{- if .Meta.Tags}}
+<meta name="keywords" content="{{range $i, $tag := .Meta.Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}">
+{{- end}}
+{{- if not .Meta.Date.IsZero}}
+<meta name="date" content="{{.Meta.Date.Format "2006-01-02T15:04:05Z07:00"}}">
+{{- end}}
+{{- range .CSSFiles}}
+<link rel="stylesheet" href="/{{.}}">
+{{- end}}
+</head>
+<body>
+{{- with .Navigation}}
+<nav class="navigation">{{.}}</nav>
+{{- end}}
+<div class="content">
+{{- with .TOC}}
+<nav class="toc">{{.}}</nav>
+{{- end}}
+{{.Body}}</div>
+{{- range .Scripts}}
+<script src="{{.Src}}"{{with .Integrity}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>
+{{- end}}
+</body>
+</html>
+`))
+
+var layoutCache struct {
+	mu        sync.Mutex
+	templates *template.Template
+	loaded    bool
+}
+
+// ResetLayouts forces the layouts to be parsed again on the next render
+func ResetLayouts() {
+	layoutCache.mu.Lock()
+	defer layoutCache.mu.Unlock()
+	layoutCache.templates = nil
+	layoutCache.loaded = false
+}
+
+// LoadLayouts parses all templates of the layout folder into one set, so layouts can include each other
+func LoadLayouts() (*template.Template, error) {
+	layoutCache.mu.Lock()
+	defer layoutCache.mu.Unlock()
+	if layoutCache.loaded {
+		return layoutCache.templates, nil
+	}
+
+	pattern := filepath.Join(AbsolutePath, LayoutFolder, "*.html")
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var templates *template.Template
+	if len(files) != 0 {
+		templates, err = template.ParseFiles(files...)
+		if err != nil {
+			err = fmt.Errorf("while parsing layouts encountered error: %w", err)
+			SetSourceError(LayoutFolder, err)
+			return nil, err
+		}
+	}
+	SetSourceError(LayoutFolder, nil)
+
+	layoutCache.templates = templates
+	layoutCache.loaded = true
+	return templates, nil
+}
+
+// LayoutPath returns the file a layout name is loaded from
+func LayoutPath(name string) string {
+	if name == "" {
+		name = DefaultLayoutName
+	}
+	return filepath.Join(AbsolutePath, LayoutFolder, name+".html")
+}
+
+// GetLayout returns the template for the layout name, falling back to the builtin default layout
+func GetLayout(name string) (*template.Template, error) {
+	if name == "" {
+		name = DefaultLayoutName
+	}
+	templates, err := LoadLayouts()
+	if err != nil {
+		return nil, err
+	}
+	if templates != nil {
+		if layout := templates.Lookup(name + ".html"); layout != nil {
+			return layout, nil
+		}
+	}
+	if name == DefaultLayoutName {
+		return BuiltinLayout, nil
+	}
+	return nil, fmt.Errorf("layout %q does not exist in %s", name, filepath.Join(FullPath, LayoutFolder))
+}
+
+func RenderLayout(data PageData) ([]byte, error) {
+	layout, err := GetLayout(data.Meta.Layout)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	err = layout.Execute(&buffer, data)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// IsLayoutFolder reports if the path is the layout folder, which is never copied to the target
+func IsLayoutFolder(path string, info fs.FileInfo) bool {
+	if !info.IsDir() || info.Name() != LayoutFolder {
+		return false
+	}
+	// walks start at FullPath or AbsolutePath, so the path is compared relative to the markdown folder
+	relative, err := SourceRelative(path)
+	return err == nil && relative == LayoutFolder
+}
+
+// IsLayoutSource reports if a path relative to the markdown folder is the layout folder or inside it
+func IsLayoutSource(relative string) bool {
+	return relative == LayoutFolder || strings.HasPrefix(relative, LayoutFolder+string(filepath.Separator))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"markdown-server/markdown/ast"
+)
+
+/*****************************************************
+*** FUNCTIONS FOR REWRITING LINKS TO GENERATED PAGES ***
+******************************************************/
+
+// PrettyURLs writes "page.md" to "page/index.html" instead of "page.html"
+var PrettyURLs = os.Getenv("PRETTY_URLS") != ""
+
+const IndexPage = "index.html"
+
+// OutputRelative returns the path relative to the target folder a source file is written to
+func OutputRelative(relative string) string {
+	if !strings.HasSuffix(relative, ".md") {
+		return relative
+	}
+	base := strings.TrimSuffix(relative, ".md")
+	if PrettyURLs && filepath.Base(base) != "index" {
+		return filepath.Join(base, IndexPage)
+	}
+	return base + ".html"
+}
+
+// OutputURL returns the URL path a file relative to the target folder is served at
+func OutputURL(outputRelative string) string {
+	result := "/" + filepath.ToSlash(outputRelative)
+	if PrettyURLs && (result == "/"+IndexPage || strings.HasSuffix(result, "/"+IndexPage)) {
+		result = strings.TrimSuffix(result, IndexPage)
+	}
+	return result
+}
+
+// SourceForOutput returns the markdown file relative to the markdown folder that generates the output
+func SourceForOutput(outputRelative string) (string, bool) {
+	if !strings.HasSuffix(outputRelative, ".html") {
+		return "", false
+	}
+	base := strings.TrimSuffix(outputRelative, ".html")
+	candidates := []string{base + ".md"}
+	if filepath.Base(base) == "index" && filepath.Dir(base) != "." {
+		candidates = append(candidates, filepath.Dir(base)+".md")
+	}
+	for _, candidate := range candidates {
+		if OutputRelative(candidate) != outputRelative {
+			continue
+		}
+		if info, err := os.Stat(SourcePath(candidate)); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// LinkRewriter points relative links and images of a page at the generated files
+type LinkRewriter struct {
+	// source is the page relative to the markdown folder
+	source string
+	// Targets are the link dependencies of all local link destinations, existing or not
+	Targets []string
+}
+
+func NewLinkRewriter(source string) *LinkRewriter {
+	return &LinkRewriter{source: source}
+}
+
+func (r *LinkRewriter) Rewrite(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering {
+			return ast.GoToNext
+		}
+		switch n := node.(type) {
+		case *ast.Link:
+			if n.NoteID == 0 && n.Footnote == nil {
+				n.Destination = r.rewrite(n.Destination)
+			}
+		case *ast.Image:
+			n.Destination = r.rewrite(n.Destination)
+		}
+		return ast.GoToNext
+	})
+}
+
+// ResolveLink returns the file relative to the markdown folder a link destination of the page points at.
+// It reports false for external links, pure fragments and destinations outside the markdown folder.
+func ResolveLink(source string, destination string) (target string, link *url.URL, ok bool) {
+	link, err := url.Parse(destination)
+	if err != nil || link.Scheme != "" || link.Host != "" || link.Opaque != "" || link.Path == "" {
+		return "", nil, false
+	}
+	if strings.HasPrefix(link.Path, "/") {
+		target = filepath.FromSlash(strings.TrimPrefix(link.Path, "/"))
+	} else {
+		target = filepath.Join(filepath.Dir(source), filepath.FromSlash(link.Path))
+	}
+	target = filepath.Clean(target)
+	if target == ".." || strings.HasPrefix(target, ".."+string(filepath.Separator)) {
+		return "", nil, false
+	}
+	return target, link, true
+}
+
+func (r *LinkRewriter) rewrite(destination []byte) []byte {
+	target, link, ok := ResolveLink(r.source, string(destination))
+	if !ok {
+		return destination
+	}
+	if dependency := LinkDependency(SourcePath(target)); !slices.Contains(r.Targets, dependency) {
+		r.Targets = append(r.Targets, dependency)
+	}
+	info, err := os.Stat(SourcePath(target))
+	if err != nil {
+		// reported by the link check
+		return destination
+	}
+
+	output := OutputRelative(target)
+	if info.IsDir() {
+		output = target
+	}
+	if strings.HasPrefix(link.Path, "/") {
+		link.Path = OutputURL(output)
+	} else {
+		relativeLink, err := filepath.Rel(filepath.Dir(OutputRelative(r.source)), output)
+		if err != nil {
+			return destination
+		}
+		link.Path = filepath.ToSlash(relativeLink)
+		if PrettyURLs && !info.IsDir() {
+			link.Path = strings.TrimSuffix(link.Path, IndexPage)
+			if link.Path == "" {
+				link.Path = "./"
+			}
+		}
+	}
+	if info.IsDir() && !strings.HasSuffix(link.Path, "/") {
+		link.Path += "/"
+	}
+	return []byte(link.String())
+}
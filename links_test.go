@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"markdown-server/markdown/ast"
+)
+
+func TestOutputRelative(t *testing.T) {
+	defer func(saved bool) { PrettyURLs = saved }(PrettyURLs)
+
+	tests := []struct {
+		name     string
+		relative string
+		pretty   bool
+		want     string
+	}{
+		{name: "page", relative: "guide.md", want: "guide.html"},
+		{name: "nested page", relative: "docs/api.md", want: "docs/api.html"},
+		{name: "other file", relative: "docs/image.png", want: "docs/image.png"},
+		{name: "pretty page", relative: "guide.md", pretty: true, want: "guide/index.html"},
+		{name: "pretty nested page", relative: "docs/api.md", pretty: true, want: "docs/api/index.html"},
+		{name: "pretty index", relative: "docs/index.md", pretty: true, want: "docs/index.html"},
+		{name: "pretty other file", relative: "style.css", pretty: true, want: "style.css"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			PrettyURLs = test.pretty
+			got := OutputRelative(filepath.FromSlash(test.relative))
+			if want := filepath.FromSlash(test.want); got != want {
+				t.Errorf("OutputRelative(%q) = %q, want %q", test.relative, got, want)
+			}
+		})
+	}
+}
+
+func TestLinkRewriter(t *testing.T) {
+	defer func(saved bool) { PrettyURLs = saved }(PrettyURLs)
+	defer func(saved string) { AbsolutePath = saved }(AbsolutePath)
+	AbsolutePath = t.TempDir()
+	for _, file := range []string{"index.md", "guide.md", "image.png", "docs/index.md", "docs/api.md", "docs/sub/page.md"} {
+		path := SourcePath(filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		destination string
+		want        string
+		pretty      string
+	}{
+		{name: "page", destination: "../guide.md", want: "../guide.html", pretty: "../../guide/"},
+		{name: "fragment", destination: "../guide.md#install", want: "../guide.html#install", pretty: "../../guide/#install"},
+		{name: "query", destination: "../guide.md?tab=2#install", want: "../guide.html?tab=2#install", pretty: "../../guide/?tab=2#install"},
+		{name: "index", destination: "index.md", want: "index.html", pretty: "../"},
+		{name: "same page", destination: "api.md#usage", want: "api.html#usage", pretty: "./#usage"},
+		{name: "absolute", destination: "/guide.md#install", want: "/guide.html#install", pretty: "/guide/#install"},
+		{name: "absolute index", destination: "/index.md", want: "/index.html", pretty: "/"},
+		{name: "directory", destination: "sub", want: "sub/", pretty: "../sub/"},
+		{name: "other file", destination: "../image.png", want: "../image.png", pretty: "../../image.png"},
+		{name: "pure fragment", destination: "#usage", want: "#usage", pretty: "#usage"},
+		{name: "external", destination: "https://example.com/guide.md", want: "https://example.com/guide.md", pretty: "https://example.com/guide.md"},
+		{name: "missing", destination: "missing.md#a", want: "missing.md#a", pretty: "missing.md#a"},
+		{name: "outside", destination: "../../outside.md", want: "../../outside.md", pretty: "../../outside.md"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, pretty := range []bool{false, true} {
+				PrettyURLs = pretty
+				want := test.want
+				if pretty {
+					want = test.pretty
+				}
+				doc := &ast.Document{}
+				link := &ast.Link{Destination: []byte(test.destination)}
+				image := &ast.Image{Destination: []byte(test.destination)}
+				ast.AppendChild(doc, link)
+				ast.AppendChild(doc, image)
+				NewLinkRewriter(filepath.Join("docs", "api.md")).Rewrite(doc)
+				if string(link.Destination) != want || string(image.Destination) != want {
+					t.Errorf("pretty URLs %t: Rewrite(%q) = %q and %q, want %q",
+						pretty, test.destination, link.Destination, image.Destination, want)
+				}
+			}
+		})
+	}
+
+	rewriter := NewLinkRewriter(filepath.Join("docs", "api.md"))
+	doc := &ast.Document{}
+	ast.AppendChild(doc, &ast.Link{Destination: []byte("missing.md")})
+	rewriter.Rewrite(doc)
+	if dependency := LinkDependency(SourcePath(filepath.Join("docs", "missing.md"))); !slices.Contains(rewriter.Targets, dependency) {
+		t.Errorf("Rewrite() targets = %q, want them to contain %q", rewriter.Targets, dependency)
+	}
+}
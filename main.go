@@ -2,6 +2,9 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
 	"log"
@@ -10,7 +13,6 @@ import (
 	"markdown-server/chroma"
 	format "markdown-server/chroma/formatters/html"
 	"markdown-server/chroma/lexers"
-	"markdown-server/chroma/styles"
 	// Locally injected version of https://www.github.com/gomarkdown/markdown v0.0.0-20250311123330-531bef5e742b
 	"markdown-server/markdown"
 	"markdown-server/markdown/ast"
@@ -18,46 +20,84 @@ import (
 	"markdown-server/markdown/parser"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 )
 
 var FullPath = os.Getenv("MARKDOWN_PATH")
 var TargetFolder = os.Getenv("HTML_TARGET_PATH")
+var BuildDrafts = os.Getenv("BUILD_DRAFTS") != ""
 
 func main() {
-	PopulateVariables()
-	CleanUpFolders()
-	WalkFileTreeTwice()
-	// two versions exist one for Windows one for the rest
-	StartServingGeneratedFiles()
+	os.Exit(RunCommandLine(os.Args[1:]))
 }
 
-var FolderName = ""
+// AbsolutePath is the absolute version of FullPath, every source path is resolved against it
+var AbsolutePath = ""
 
-func PopulateVariables() {
-	posForFolder := strings.LastIndex(FullPath, string(os.PathSeparator))
-	FolderName = FullPath[posForFolder+1:]
+// PopulateVariables resolves the markdown folder and applies its config file. A broken config file
+// is returned as error and the defaults are applied instead, so a watching server can wait for a fix.
+func PopulateVariables() error {
+	var err error
+	AbsolutePath, err = filepath.Abs(FullPath)
+	if err != nil {
+		log.Fatalf("While resolving the markdown path encountered error: %v", err)
+	}
+	config, err := LoadConfig()
+	if err != nil {
+		ApplyConfig(DefaultConfig())
+		return err
+	}
+	ApplyConfig(config)
+	return nil
 }
 
 func CleanUpFolders() {
 	CSSFileList = make([]string, 0)
-	err := os.RemoveAll(TargetFolder)
+	SourceFileList = make([]string, 0)
+	Graph = NewDependencyGraph()
+	SiteLinks = NewLinkIndex()
+	SiteWarnings = NewWarningIndex()
+	SiteNavigation = NewNavIndex()
+	SiteSearch = NewSearchIndex()
+	ResetLayouts()
+	err := RemoveTarget(TargetFolder)
 	if err != nil {
 		log.Fatalf("While deleting old files encountered error: %v", err)
 	}
 }
 
-func WalkFileTreeTwice() {
-	err := filepath.Walk(FullPath, WalkAndCopyCSSFilesAndFolders)
+// WalkFileTreeTwice builds the whole target folder. Files that fail are reported and left out,
+// the build goes on with the others and reports false at the end.
+func WalkFileTreeTwice() bool {
+	err := WalkSources(FullPath, WalkAndCopyCSSFilesAndFolders)
 	if err != nil {
 		log.Fatalf("While transfering css files/creating folders encountered error: %v", err)
 	}
 
-	err = filepath.Walk(FullPath, WalkAndCopyMarkdownFiles)
+	err = WalkSources(FullPath, WalkAndCollectSourceFiles)
 	if err != nil {
-		log.Fatalf("While converting + copying markdown files encountered error: %v", err)
+		log.Fatalf("While collecting markdown files encountered error: %v", err)
 	}
+
+	SiteNavigation.Refresh(".")
+	stats, err := BuildFiles(SourceFileList)
+	if err != nil {
+		log.Printf("While converting + copying markdown files encountered error: %v", err)
+	}
+	generatedErr := BuildGeneratedPages()
+	if generatedErr != nil {
+		log.Printf("While generating the index, search and stylesheet files encountered error: %v", generatedErr)
+	}
+	log.Printf("Build %s", stats)
+	LogWarnings()
+	LogLinkProblems()
+	return stats.Failed == 0 && generatedErr == nil
+}
+
+// BuildGeneratedPages writes the files without a source: directory index pages, the search page and search index
+// and the highlighting stylesheets
+func BuildGeneratedPages() error {
+	return errors.Join(BuildDirectoryIndexes(), WriteSearchPage(), WriteSearchIndex(), WriteHighlightStylesheets())
 }
 
 /*******************************************
@@ -66,46 +106,96 @@ func WalkFileTreeTwice() {
 
 var CSSFileList []string
 
-func WalkAndCopyCSSFilesAndFolders(path string, info fs.FileInfo, err error) error {
-	if path == FullPath {
-		err = os.MkdirAll(TargetFolder, 0700)
-		return err
+// SourceFileList contains every file to convert or copy, relative to the markdown folder
+var SourceFileList []string
+
+// SourceRelative returns the path of a source file relative to the markdown folder
+func SourceRelative(path string) (string, error) {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
 	}
-	path = strings.TrimPrefix(path, FolderName)
-	if info.IsDir() {
-		err = os.MkdirAll(TargetFolder+path, 0700)
+	return filepath.Rel(AbsolutePath, absolutePath)
+}
+
+// IsInside reports if the path relative to the markdown folder is relative or, if relative is a directory,
+// inside of it
+func IsInside(path string, relative string) bool {
+	return relative == "." || path == relative || strings.HasPrefix(path, relative+string(filepath.Separator))
+}
+
+// RemoveInside deletes the entries of a per page index that are inside relative, see IsInside.
+// It reports if an entry was deleted.
+func RemoveInside[T any](pages map[string]T, relative string) bool {
+	removed := false
+	for page := range pages {
+		if IsInside(page, relative) {
+			delete(pages, page)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// SourcePath returns the absolute path of a file relative to the markdown folder
+func SourcePath(relative string) string {
+	return filepath.Join(AbsolutePath, relative)
+}
+
+// TargetPath returns the path in the target folder a file relative to the markdown folder is written to
+func TargetPath(relative string) string {
+	return filepath.Join(TargetFolder, OutputRelative(relative))
+}
+
+// IsCSSListEntry reports if the file is a stylesheet that gets linked in every page
+func IsCSSListEntry(relative string) bool {
+	return strings.HasSuffix(relative, ".css") && !strings.ContainsRune(relative, filepath.Separator)
+}
+
+// WalkSources walks the root like filepath.Walk, passing the paths relative to the markdown folder.
+// The layout folder and the config files are left out, they are never built.
+func WalkSources(root string, walkFn func(relative string, info fs.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		return err
-	}
-	if strings.HasSuffix(path, ".css") && strings.Count(path, string(os.PathSeparator)) == 1 {
+		if IsLayoutFolder(path, info) {
+			return filepath.SkipDir
+		}
+		relative, err := SourceRelative(path)
 		if err != nil {
 			return err
 		}
+		if !info.IsDir() && IsConfigSource(relative) {
+			return nil
+		}
+		return walkFn(relative, info)
+	})
+}
+
+func WalkAndCopyCSSFilesAndFolders(relative string, info fs.FileInfo) error {
+	if info.IsDir() {
+		return MakeTargetFolder(TargetPath(relative))
+	}
+	if IsCSSListEntry(relative) {
 		CSSFileList = append(CSSFileList, info.Name())
-		return err
 	}
-	return err
+	return nil
 }
 
-func WalkAndCopyMarkdownFiles(path string, info fs.FileInfo, err error) error {
-	if path == FullPath || info.IsDir() {
-		return err
+func WalkAndCollectSourceFiles(relative string, info fs.FileInfo) error {
+	if !info.IsDir() {
+		SourceFileList = append(SourceFileList, relative)
 	}
-	path = strings.TrimPrefix(path, FolderName)
-	if strings.HasSuffix(path, ".md") {
-		err = CopyAndTransformMarkdownFile(FullPath+path, TargetFolder+path)
-		if err != nil {
-			return err
-		}
-	} else {
-		err = CopyFile(FullPath+path, TargetFolder+path)
-		if err != nil {
-			return err
-		}
+	return nil
+}
+
+// BuildSourceFile converts a markdown file or copies any other file into the target folder
+func BuildSourceFile(relative string) error {
+	if strings.HasSuffix(relative, ".md") {
+		return CopyAndTransformMarkdownFile(SourcePath(relative), TargetPath(relative))
 	}
-	return err
+	return CopyFile(SourcePath(relative), TargetPath(relative))
 }
 
 func CopyFile(src, dst string) error {
@@ -113,8 +203,7 @@ func CopyFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(dst, data, 0644)
-	return err
+	return WriteTarget(dst, data)
 }
 
 func CopyAndTransformMarkdownFile(src, dst string) error {
@@ -123,126 +212,240 @@ func CopyAndTransformMarkdownFile(src, dst string) error {
 		return err
 	}
 
-	data = GenerateHTMLFromMarkdown(data)
+	page, err := GenerateHTMLFromMarkdown(src, data)
+	// the dependencies are known even if the page failed, so fixing an include rebuilds it
+	Graph.SetDependencies(src, page.Dependencies)
+	if err != nil {
+		SiteWarnings.Set(mustRelative(src), append(page.Warnings, PageError(src, err)))
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	SiteWarnings.Set(mustRelative(src), page.Warnings)
+	if page.Links != nil {
+		SiteLinks.Set(mustRelative(src), page.Links)
+	}
+	if page.Meta.Draft && !BuildDrafts {
+		// a page that became a draft must not stay published
+		SiteSearch.Remove(mustRelative(src))
+		return RemoveTarget(dst)
+	}
 
-	err = os.WriteFile(dst, data, 0644)
-	return err
+	SiteSearch.Set(mustRelative(src), page.Sections)
+	return WriteTarget(dst, page.HTML)
 }
 
 /**********************************************
 *** FUNCTIONS TRANSFORMING MARKDOWN TO HTML ***
 ***********************************************/
 
+// Extensions are the default parser extensions, the config file can change them per directory.
+// Includes are left out, since every paragraph starting with "{{" would become one, they are turned on
+// with parser.enable = ["includes"].
 var Extensions = parser.NoIntraEmphasis | parser.Tables | parser.FencedCode |
 	parser.Autolink | parser.Strikethrough | parser.SpaceHeadings | parser.OrderedListStart |
 	parser.BackslashLineBreak | parser.DefinitionLists | parser.EmptyLinesBreakList | parser.Footnotes |
-	parser.SuperSubscript
-var TitleExpression = regexp.MustCompile(`---\s*\ntitle: (.*?)\n---\s*\n`)
+	parser.SuperSubscript | parser.HeadingIDs
+
+// Page is the result of converting a single markdown file
+type Page struct {
+	Source string
+	Meta   PageMeta
+	HTML   []byte
+	// Dependencies are all inputs besides the source file the page was generated from
+	Dependencies []string
+	// Links are the anchors and original link destinations of the page for the link check
+	Links *PageLinks
+	// Sections are the plain text of the page for the search index
+	Sections []SearchSection
+	// Warnings are the problems of the page that did not stop it from being built
+	Warnings []Warning
+}
 
-func GenerateHTMLFromMarkdown(markdownText []byte) []byte {
-	titleText := ""
-	result := TitleExpression.FindSubmatch(markdownText)
-	if result != nil {
-		markdownText = markdownText[len(result[0]):]
-		titleText = string(result[1])
-	}
+func GenerateHTMLFromMarkdown(src string, markdownText []byte) (*Page, error) {
+	page := &Page{Source: src, Dependencies: []string{CSSListDependency, NavigationDependency}}
 
 	markdownText = markdown.NormalizeNewlines(markdownText)
-	markdownText = markdown.ToHTML(markdownText, parser.NewWithExtensions(Extensions), GetRenderer())
-
-	markdownText = append([]byte("<!DOCTYPE html>"+
-		"<html lang=\"de\">"+
-		"<head>"+
-		"<meta charset=\"UTF-8\">"+
-		"<title>"+titleText+"</title>"+
-		GetCSSLinkTags()+
-		"</head>"+
-		"<body>"+
-		"<div class=\"content\">"),
-		markdownText...)
-	markdownText = append(markdownText, []byte("</div></body></html>")...)
-
-	return markdownText
+	fullText := markdownText
+	meta, markdownText, err := ParseFrontMatter(markdownText)
+	page.Meta = meta
+	if err != nil {
+		return page, err
+	}
+	page.Dependencies = append(page.Dependencies, LayoutPath(meta.Layout))
+
+	settings := GetConfig().PageSettings(mustRelative(src))
+	includes := NewIncludeReader(src)
+	p := parser.NewWithExtensions(settings.ParserExtensions())
+	p.Opts.ReadIncludeFn = includes.Read
+	doc := markdown.Parse(markdownText, p)
+	AssignHeadingIDs(doc)
+	page.Links = CollectPageLinks(meta, doc, fullText)
+	toc := ApplyTOC(doc, meta, settings)
+	page.Sections = ExtractSearchSections(doc, PageTitle(src, meta), OutputURL(OutputRelative(mustRelative(src))))
+	links := NewLinkRewriter(mustRelative(src))
+	links.Rewrite(doc)
+	ctx := NewRenderContext(src, settings, fullText)
+	body := markdown.Render(doc, GetRenderer(ctx))
+	page.Warnings = ctx.Warnings
+	// links are dependencies as well, their destination changes once the target is created or removed
+	page.Dependencies = append(page.Dependencies, includes.Files...)
+	page.Dependencies = append(page.Dependencies, links.Targets...)
+	if includes.Err != nil {
+		return page, includes.Err
+	}
+
+	page.HTML, err = RenderLayout(PageData{
+		Title:      PageTitle(src, meta),
+		Meta:       meta,
+		Body:       template.HTML(body),
+		CSSFiles:   PageStylesheets(settings),
+		Navigation: NavigationHTML(SiteNavigation.Tree(), mustRelative(src)),
+		TOC:        toc,
+		Scripts:    ctx.Scripts,
+	})
+	return page, err
+}
+
+// PageTitle returns the title of the front matter or the file name
+func PageTitle(src string, meta PageMeta) string {
+	if meta.Title != "" {
+		return meta.Title
+	}
+	return strings.TrimSuffix(filepath.Base(src), ".md")
 }
 
-func GetRenderer() *html.Renderer {
+// GetRenderer returns the renderer for a page, its render hooks share the context
+func GetRenderer(ctx *RenderContext) *html.Renderer {
 	opts := html.RendererOptions{
-		Flags:          html.CommonFlags,
-		RenderNodeHook: SpecialCodeBlockRenderHook,
+		Flags:          ctx.Settings.RendererFlags,
+		RenderNodeHook: SpecialCodeBlockRenderHook(ctx),
 	}
 	return html.NewRenderer(opts)
 }
 
-func GetCSSLinkTags() string {
-	result := ""
-	for _, entry := range CSSFileList {
-		result += "<link rel=\"stylesheet\" href=\"/" + entry + "\">\n"
+func SpecialCodeBlockRenderHook(ctx *RenderContext) html.RenderNodeFunc {
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		switch node.(type) {
+		case *ast.CodeBlock:
+			CodeBlock(w, node.(*ast.CodeBlock), ctx)
+		case *ast.Math, *ast.MathBlock:
+			if entering {
+				MathNode(w, node, ctx)
+			}
+			return ast.SkipChildren, true
+		default:
+			return ast.GoToNext, false
+		}
+		return ast.GoToNext, true
 	}
-	return result
 }
 
-func SpecialCodeBlockRenderHook(w io.Writer, node ast.Node, _ bool) (ast.WalkStatus, bool) {
-	switch node.(type) {
-	case *ast.CodeBlock:
-		CodeBlock(w, node.(*ast.CodeBlock))
-	default:
-		return ast.GoToNext, false
+// CodeBlock writes a highlighted code block. Problems with the block are reported as warnings of the page,
+// a block that can not be highlighted is written as plain code.
+func CodeBlock(w io.Writer, node *ast.CodeBlock, ctx *RenderContext) {
+	ctx.blocks++
+	line := ctx.lines.Locate(node.Literal)
+	if node.IsFenced && line > 1 {
+		// the code starts below the opening fence
+		line--
+	}
+
+	var block bytes.Buffer
+	block.WriteString("\n")
+	var info CodeInfo
+	if len(node.Info) != 0 {
+		var err error
+		info, err = ParseCodeInfo(string(node.Info))
+		if err != nil {
+			ctx.Warn(line, "code block info %q: %v", node.Info, err)
+		}
+	}
+	if !RenderFencedBlock(&block, &FencedBlock{Info: info, Source: node.Literal, Line: line, Page: ctx}) {
+		HighlightCodeBlock(&block, node, info, line, ctx)
+	}
+	block.WriteString("\n")
+
+	if _, err := w.Write(block.Bytes()); err != nil {
+		ctx.Warn(line, "code block could not be written: %v", err)
 	}
-	return ast.GoToNext, true
 }
 
-func CodeBlock(w io.Writer, node *ast.CodeBlock) {
-	_, _ = w.Write([]byte("\n"))
+// HighlightCodeBlock writes the code highlighted with the lexer for the language of the block.
+// A block without a language stays plain unless its language is detected.
+func HighlightCodeBlock(w *bytes.Buffer, node *ast.CodeBlock, info CodeInfo, line int, ctx *RenderContext) {
+	lexer, detected := ctx.Settings.Lexer(info, node.Literal)
+	if len(node.Info) == 0 && lexer == nil {
+		WritePlainCode(w, node.Literal)
+		return
+	}
 
-	if len(node.Info) != 0 {
-		Format(w, node.Literal, node.Info)
-	} else {
-		_, _ = w.Write([]byte("<pre><code>"))
-		EscapeHTML(w, bytes.TrimSpace(node.Literal))
-		_, _ = w.Write([]byte("</code></pre>"))
+	if info.Title != "" {
+		w.WriteString(`<div class="code-title">`)
+		_ = EscapeHTML(w, []byte(info.Title))
+		w.WriteString("</div>\n")
+	}
+	if detected {
+		_, _ = fmt.Fprintf(w, `<div class="code-detected" data-language="%s">`, template.HTMLEscapeString(LexerName(lexer)))
+	}
+	var code bytes.Buffer
+	err := Format(&code, node.Literal, lexer, ctx.Settings.Formatter(info, fmt.Sprintf("code%d-", ctx.blocks)), ctx.Settings)
+	if err != nil {
+		ctx.Warn(line, "code block could not be highlighted: %v", err)
+		code.Reset()
+		WritePlainCode(&code, node.Literal)
 	}
-	_, _ = w.Write([]byte("\n"))
+	_, _ = w.Write(code.Bytes())
+	if detected {
+		w.WriteString("</div>")
+	}
+}
+
+// WritePlainCode writes the code escaped and without highlighting
+func WritePlainCode(w *bytes.Buffer, source []byte) {
+	w.WriteString("<pre><code>")
+	_ = EscapeHTML(w, bytes.TrimSpace(source))
+	w.WriteString("</code></pre>")
 }
 
-func Format(writer io.Writer, source []byte, language []byte) {
-	l := lexers.Get(string(language))
+// Format highlights the code with the formatter and the style of the settings. The formatter of the settings
+// is shared by all pages, code blocks with attributes get their own. Without a lexer the code is not highlighted.
+func Format(writer io.Writer, source []byte, l chroma.Lexer, formatter *format.Formatter, settings *PageConfig) error {
 	if l == nil {
 		l = lexers.Fallback
 	}
 
 	l = chroma.Coalesce(l)
 
-	formatter := format.New(format.WithClasses(true), format.Standalone(false), format.WithLineNumbers(true))
-
-	s := styles.Get("github")
-	if s == nil {
-		s = styles.Fallback
-	}
-
-	s.Types()
 	it, err := l.Tokenise(nil, string(SpecialTrim(source)))
 	if err != nil {
-		return
+		return err
 	}
 
-	_ = formatter.Format(writer, s, it)
+	return formatter.Format(writer, settings.style, it)
 }
 
-func EscapeHTML(w io.Writer, d []byte) {
+// EscapeHTML writes the text with the characters escaped that have a meaning in html,
+// it stops at the first failed write
+func EscapeHTML(w io.Writer, d []byte) error {
 	var start, end int
 	n := len(d)
 	for end < n {
 		escSeq := Escaper[d[end]]
 		if escSeq != nil {
-			_, _ = w.Write(d[start:end])
-			_, _ = w.Write(escSeq)
+			if _, err := w.Write(d[start:end]); err != nil {
+				return err
+			}
+			if _, err := w.Write(escSeq); err != nil {
+				return err
+			}
 			start = end + 1
 		}
 		end++
 	}
 	if start < n && end <= n {
-		_, _ = w.Write(d[start:end])
+		_, err := w.Write(d[start:end])
+		return err
 	}
+	return nil
 }
 
 var Escaper = [256][]byte{
@@ -252,7 +455,11 @@ var Escaper = [256][]byte{
 	'"': []byte("&quot;"),
 }
 
+// SpecialTrim removes the last line break of the code, a block without one is returned unchanged
 func SpecialTrim(input []byte) []byte {
 	end := bytes.LastIndexByte(input, '\n')
+	if end == -1 {
+		return input
+	}
 	return input[:end]
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"markdown-server/markdown/ast"
+	"markdown-server/markdown/parser"
+)
+
+/**************************************
+*** FUNCTIONS FOR RENDERING MATH ***
+***************************************/
+
+// MathConfig holds the settings for formulas written as $…$ and $$…$$
+type MathConfig struct {
+	// Enabled turns on the mathjax parser extension
+	Enabled bool
+	// Scripts are loaded by pages containing a formula, they typeset the \(…\) and \[…\] of the markup,
+	// like the MathJax bundle placed in the markdown folder as "/mathjax/tex-chtml.js". No script is
+	// bundled, without one the formulas are shown as their markup.
+	Scripts []string
+}
+
+// ParserExtensions returns the extensions the pages are parsed with
+func (page *PageConfig) ParserExtensions() parser.Extensions {
+	if page.Math.Enabled {
+		return page.Extensions | parser.MathJax
+	}
+	return page.Extensions
+}
+
+// MathNode writes inline formulas in a span and formulas of their own in a div,
+// the scripts of the math settings are added to the scripts of the page. Without scripts the
+// page gets a single warning.
+func MathNode(w io.Writer, node ast.Node, ctx *RenderContext) {
+	for _, script := range ctx.Settings.Math.Scripts {
+		ctx.AddScripts(Script{Src: script})
+	}
+	if len(ctx.Settings.Math.Scripts) == 0 && !ctx.mathWarned {
+		ctx.mathWarned = true
+		ctx.Warn(0, "math.scripts is not set, the formulas are shown as their markup")
+	}
+	var formula bytes.Buffer
+	switch node := node.(type) {
+	case *ast.Math:
+		formula.WriteString(`<span class="math inline">\(`)
+		_ = EscapeHTML(&formula, node.Literal)
+		formula.WriteString(`\)</span>`)
+	case *ast.MathBlock:
+		formula.WriteString("\n<div class=\"math display\">\\[")
+		_ = EscapeHTML(&formula, node.Literal)
+		formula.WriteString("\\]</div>\n")
+	}
+	if _, err := w.Write(formula.Bytes()); err != nil {
+		ctx.Warn(0, "formula could not be written: %v", err)
+	}
+}
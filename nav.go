@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"markdown-server/markdown"
+)
+
+/*****************************************************
+*** FUNCTIONS FOR THE NAVIGATION AND INDEX PAGES ***
+******************************************************/
+
+// NavigationDependency is the input representing the titles, weights and existence of all pages
+const NavigationDependency = "<navigation>"
+
+// NavEntry is what the navigation needs to know about a single page
+type NavEntry struct {
+	// Title is empty if the front matter has none
+	Title   string
+	Weight  int
+	Draft   bool
+	modTime time.Time
+}
+
+// NavIndex holds the navigation entries of all pages by their path relative to the markdown folder
+type NavIndex struct {
+	mu      sync.Mutex
+	entries map[string]NavEntry
+	// version is increased whenever an entry visible in the navigation changes
+	version int
+	// tree is built on first use after a change, it is shared by all pages and must not be modified
+	tree *NavNode
+}
+
+// SiteNavigation is the navigation of the current build, it is replaced on every full rebuild
+var SiteNavigation = NewNavIndex()
+
+func NewNavIndex() *NavIndex {
+	return &NavIndex{entries: make(map[string]NavEntry)}
+}
+
+func (index *NavIndex) Version() int {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	return index.version
+}
+
+// Refresh brings the entries of the markdown file or directory relative to the markdown folder up to date.
+// Only files with a new modification time are read again. It reports if the navigation changed.
+func (index *NavIndex) Refresh(relative string) bool {
+	before := index.Version()
+	found := make(map[string]bool)
+	root := SourcePath(relative)
+	_ = filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if IsLayoutFolder(path, info) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		fileRelative, err := SourceRelative(path)
+		if err != nil {
+			return nil
+		}
+		found[fileRelative] = true
+
+		index.mu.Lock()
+		entry, known := index.entries[fileRelative]
+		index.mu.Unlock()
+		if known && entry.modTime.Equal(info.ModTime()) {
+			return nil
+		}
+		index.set(fileRelative, ReadNavEntry(path, info))
+		return nil
+	})
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for page := range index.entries {
+		if IsInside(page, relative) && !found[page] {
+			delete(index.entries, page)
+			index.version++
+			index.tree = nil
+		}
+	}
+	return index.version != before
+}
+
+func (index *NavIndex) set(relative string, entry NavEntry) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	old, known := index.entries[relative]
+	index.entries[relative] = entry
+	if !known || old.Title != entry.Title || old.Weight != entry.Weight || old.Draft != entry.Draft {
+		index.version++
+		index.tree = nil
+	}
+}
+
+// ReadNavEntry reads the front matter of a markdown file. Pages with broken front matter
+// still appear in the navigation, the build reports their error.
+func ReadNavEntry(path string, info fs.FileInfo) NavEntry {
+	entry := NavEntry{modTime: info.ModTime()}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entry
+	}
+	meta, _, err := ParseFrontMatter(markdown.NormalizeNewlines(data))
+	if err != nil {
+		return entry
+	}
+	entry.Title = meta.Title
+	entry.Weight = meta.Weight
+	entry.Draft = meta.Draft
+	return entry
+}
+
+// NavNode is a page or a directory in the navigation tree
+type NavNode struct {
+	Name  string
+	Title string
+	URL   string
+	// Source is the page relative to the markdown folder, for a directory its index page if one exists
+	Source   string
+	Weight   int
+	Children []*NavNode
+	IsDir    bool
+}
+
+// Tree returns the navigation tree of all published pages, directories without pages are left out
+func (index *NavIndex) Tree() *NavNode {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.tree != nil {
+		return index.tree
+	}
+
+	root := &NavNode{Name: ".", Title: filepath.Base(AbsolutePath), URL: OutputURL(IndexPage), IsDir: true}
+	directories := map[string]*NavNode{".": root}
+	var directoryFor func(relative string) *NavNode
+	directoryFor = func(relative string) *NavNode {
+		if node, ok := directories[relative]; ok {
+			return node
+		}
+		node := &NavNode{
+			Name:  filepath.Base(relative),
+			Title: filepath.Base(relative),
+			URL:   OutputURL(filepath.Join(relative, IndexPage)),
+			IsDir: true,
+		}
+		directories[relative] = node
+		parent := directoryFor(filepath.Dir(relative))
+		parent.Children = append(parent.Children, node)
+		return node
+	}
+
+	for _, relative := range SortedKeys(index.entries) {
+		entry := index.entries[relative]
+		if entry.Draft && !BuildDrafts {
+			continue
+		}
+		directory := directoryFor(filepath.Dir(relative))
+		if filepath.Base(relative) == "index.md" {
+			// the index page represents its directory
+			if entry.Title != "" {
+				directory.Title = entry.Title
+			}
+			directory.Weight = entry.Weight
+			directory.Source = relative
+			continue
+		}
+		title := entry.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(relative), ".md")
+		}
+		directory.Children = append(directory.Children, &NavNode{
+			Name:   filepath.Base(relative),
+			Title:  title,
+			URL:    OutputURL(OutputRelative(relative)),
+			Source: relative,
+			Weight: entry.Weight,
+		})
+	}
+	root.sort()
+	index.tree = root
+	return root
+}
+
+// sort orders the children by weight, children without a weight by name
+func (node *NavNode) sort() {
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if (a.Weight == 0) != (b.Weight == 0) {
+			return a.Weight != 0
+		}
+		if a.Weight != b.Weight {
+			return a.Weight < b.Weight
+		}
+		return a.Name < b.Name
+	})
+	for _, child := range node.Children {
+		child.sort()
+	}
+}
+
+// Find returns the directory node for the path relative to the markdown folder
+func (node *NavNode) Find(relative string) *NavNode {
+	if relative == "." {
+		return node
+	}
+	parent := node.Find(filepath.Dir(relative))
+	if parent == nil {
+		return nil
+	}
+	for _, child := range parent.Children {
+		if child.IsDir && child.Name == filepath.Base(relative) {
+			return child
+		}
+	}
+	return nil
+}
+
+var NavigationTemplate = template.Must(template.New("navigation").Parse(`
+{{- define "list"}}<ul>
+{{- range .Nodes}}
+<li{{if eq .Source $.Current}} class="active"{{end}}><a href="{{.URL}}"{{if eq .Source $.Current}} aria-current="page"{{end}}>{{.Title}}</a>
+{{- if .Children}}{{template "list" ($.With .Children)}}{{end}}</li>
+{{- end}}
+</ul>{{end}}
+{{- template "list" .}}`))
+
+// navigationData carries the page the navigation is rendered for through the recursive template
+type navigationData struct {
+	Nodes   []*NavNode
+	Current string
+}
+
+func (data navigationData) With(nodes []*NavNode) navigationData {
+	return navigationData{Nodes: nodes, Current: data.Current}
+}
+
+// NavigationHTML renders the navigation tree with the page relative to the markdown folder marked as active
+func NavigationHTML(tree *NavNode, current string) template.HTML {
+	nodes := tree.Children
+	if tree.Source != "" {
+		home := &NavNode{Title: tree.Title, URL: tree.URL, Source: tree.Source}
+		nodes = append([]*NavNode{home}, nodes...)
+	}
+	if len(nodes) == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	_ = NavigationTemplate.Execute(&buffer, navigationData{Nodes: nodes, Current: current})
+	return template.HTML(buffer.String())
+}
+
+var DirectoryIndexTemplate = template.Must(template.New("index").Parse(`<h1>{{.Title}}</h1>
+<ul class="directory-index">
+{{- range .Children}}
+<li{{if .IsDir}} class="directory"{{end}}><a href="{{.URL}}">{{.Title}}</a></li>
+{{- end}}
+</ul>
+`))
+
+// GenerateDirectoryIndex renders the index page of a directory without an index.md
+func GenerateDirectoryIndex(tree *NavNode, directory *NavNode, relative string) ([]byte, error) {
+	var body bytes.Buffer
+	err := DirectoryIndexTemplate.Execute(&body, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := PageMeta{Title: directory.Title, Lang: DefaultLang, Custom: map[string]any{}}
+	if _, err := os.Stat(LayoutPath("index")); err == nil {
+		meta.Layout = "index"
+	}
+	return RenderLayout(PageData{
+		Title:      meta.Title,
+		Meta:       meta,
+		Body:       template.HTML(body.String()),
+		CSSFiles:   PageStylesheets(GetConfig().PageSettings(filepath.Join(relative, "index.md"))),
+		Navigation: NavigationHTML(tree, filepath.Join(relative, "index.md")),
+	})
+}
+
+// NeedsDirectoryIndex reports if the directory relative to the markdown folder has no page of its own
+func NeedsDirectoryIndex(directory *NavNode, relative string) bool {
+	_, exists := SourceForOutput(filepath.Join(relative, IndexPage))
+	return directory.Source == "" && !exists
+}
+
+// BuildDirectoryIndexes writes the index pages of all directories containing pages but no index.md
+func BuildDirectoryIndexes() error {
+	tree := SiteNavigation.Tree()
+	errs := make([]error, 0)
+	var walk func(node *NavNode, relative string)
+	walk = func(node *NavNode, relative string) {
+		if NeedsDirectoryIndex(node, relative) {
+			page, err := GenerateDirectoryIndex(tree, node, relative)
+			if err == nil {
+				err = WriteTarget(filepath.Join(TargetFolder, relative, IndexPage), page)
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+		for _, child := range node.Children {
+			if child.IsDir {
+				walk(child, filepath.Join(relative, child.Name))
+			}
+		}
+	}
+	walk(tree, ".")
+	return errors.Join(errs...)
+}
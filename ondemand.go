@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**************************************************
+*** FUNCTIONS FOR RENDERING MARKDOWN ON REQUEST ***
+***************************************************/
+
+// OnDemand serves the markdown folder directly instead of generating the target folder first.
+// It is enabled with ON_DEMAND or when no HTML_TARGET_PATH is given.
+var OnDemand = os.Getenv("ON_DEMAND") != "" || TargetFolder == ""
+
+// inputState identifies a version of an input file without reading it
+type inputState struct {
+	modTime time.Time
+	size    int64
+}
+
+func getInputState(path string) inputState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return inputState{}
+	}
+	return inputState{modTime: info.ModTime(), size: info.Size()}
+}
+
+type cachedPage struct {
+	source inputState
+	hash   [sha256.Size]byte
+	html   []byte
+	draft  bool
+	// inputs are the include files of the page
+	inputs map[string]inputState
+	// links records for every link destination whether it existed
+	links map[string]bool
+	// layouts, cssList and navigation are the states of the shared inputs when the page was rendered
+	layouts    string
+	cssList    string
+	navigation int
+}
+
+// OnDemandHandler renders markdown files of the markdown folder when they are requested.
+// Rendered pages are cached until the source, an include, a layout, the stylesheet list or the navigation changes.
+type OnDemandHandler struct {
+	static http.Handler
+
+	mu    sync.Mutex
+	cache map[string]*cachedPage
+	// renderMu serializes rendering, as rendering reads the global CSSFileList and layout cache
+	renderMu sync.Mutex
+	// layouts is the layout folder state the layout cache and config were loaded with
+	layouts string
+	// navigation is the navigation version the last change was described with
+	navigation int
+	// Reported is called after the warnings of a rendered page were recorded, so the browsers can show them
+	Reported func()
+}
+
+func NewOnDemandHandler() *OnDemandHandler {
+	SiteNavigation.Refresh(".")
+	return &OnDemandHandler{
+		static:     http.FileServer(http.Dir(AbsolutePath)),
+		cache:      make(map[string]*cachedPage),
+		navigation: SiteNavigation.Version(),
+	}
+}
+
+func (h *OnDemandHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	relative := filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/"))
+	if IsLayoutSource(relative) || IsConfigSource(relative) {
+		http.NotFound(w, r)
+		return
+	}
+	if strings.HasSuffix(relative, ".md") {
+		// markdown files are only reachable under the url of their generated page
+		http.Redirect(w, r, OutputURL(OutputRelative(relative)), http.StatusMovedPermanently)
+		return
+	}
+
+	if relative == SearchIndexFile && !fileExists(SourcePath(relative)) {
+		h.RenderAll()
+		data, err := SearchIndexJSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write(data)
+		return
+	}
+
+	if page := GeneratedStylesheet(relative); page != nil {
+		css, err := page.StylesheetCSS()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		_, _ = w.Write(css)
+		return
+	}
+
+	output := relative
+	if info, err := os.Stat(SourcePath(relative)); err == nil && info.IsDir() || path.Ext(relative) == "" {
+		output = filepath.Join(relative, IndexPage)
+	}
+	source, ok := SourceForOutput(output)
+	directory := !ok && filepath.Base(output) == IndexPage && h.HasDirectoryIndex(filepath.Dir(output))
+	search := !ok && output == SearchPage
+	if !ok && !directory && !search {
+		h.static.ServeHTTP(w, r)
+		return
+	}
+	if output != relative && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	var page []byte
+	var err error
+	if directory {
+		page, err = h.RenderDirectoryIndex(filepath.Dir(output))
+	} else if search {
+		page, err = h.RenderSearchPage()
+	} else {
+		page, err = h.Render(source)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("While rendering '%s' encountered error: %v", relative, err)
+		// an html page gets the reload script, so it reloads once the error is fixed
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("<!DOCTYPE html>\n<title>Error</title>\n<pre>" + html.EscapeString(err.Error()) + "</pre>\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+// sharedInputs are the states of the inputs every page depends on
+type sharedInputs struct {
+	layouts    string
+	cssList    []string
+	navigation int
+}
+
+// readSharedInputs refreshes the navigation and reads the states of the shared inputs
+func readSharedInputs() (sharedInputs, error) {
+	layouts := LayoutFolderState()
+	cssList, err := ReadCSSFileList()
+	if err != nil {
+		return sharedInputs{}, err
+	}
+	SiteNavigation.Refresh(".")
+	return sharedInputs{layouts: layouts, cssList: cssList, navigation: SiteNavigation.Version()}, nil
+}
+
+// Render returns the page for the markdown file relative to the markdown folder, rendering it only if
+// the cached version is outdated
+func (h *OnDemandHandler) Render(relative string) ([]byte, error) {
+	shared, err := readSharedInputs()
+	if err != nil {
+		return nil, err
+	}
+	return h.render(relative, shared)
+}
+
+// render is Render with the shared inputs already read, so rendering many pages reads them only once
+func (h *OnDemandHandler) render(relative string, shared sharedInputs) ([]byte, error) {
+	source := SourcePath(relative)
+	state := getInputState(source)
+	layouts, cssList, navigation := shared.layouts, shared.cssList, shared.navigation
+
+	entry := h.cached(relative)
+	if entry.fresh(state, shared) {
+		return entry.result()
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		h.forget(relative)
+		h.report(relative, nil)
+		SiteSearch.Remove(relative)
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	if entry != nil && entry.hash == hash && entry.sharedInputsMatch(layouts, cssList, navigation) {
+		// only the modification time changed
+		h.remember(relative, entry.withSource(state))
+		return entry.result()
+	}
+
+	// diagram commands can take long, they must not hold up the rendering of other pages
+	PrepareDiagrams(source, data)
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.useSharedInputs(layouts, cssList)
+
+	page, err := GenerateHTMLFromMarkdown(source, data)
+	for _, warning := range page.Warnings {
+		log.Println(warning)
+	}
+	if err != nil {
+		h.forget(relative)
+		h.report(relative, append(page.Warnings, PageError(source, err)))
+		SiteSearch.Remove(relative)
+		return nil, err
+	}
+	h.report(relative, page.Warnings)
+	if page.Meta.Draft && !BuildDrafts {
+		SiteSearch.Remove(relative)
+	} else {
+		SiteSearch.Set(relative, page.Sections)
+	}
+	entry = &cachedPage{
+		source:     state,
+		hash:       hash,
+		html:       page.HTML,
+		draft:      page.Meta.Draft && !BuildDrafts,
+		inputs:     make(map[string]inputState),
+		links:      make(map[string]bool),
+		layouts:    layouts,
+		cssList:    strings.Join(cssList, "\n"),
+		navigation: navigation,
+	}
+	for _, dependency := range page.Dependencies {
+		if target, isLink := strings.CutPrefix(dependency, LinkDependency("")); isLink {
+			entry.links[target] = fileExists(target)
+		} else if dependency != CSSListDependency && dependency != NavigationDependency && !IsLayoutSource(mustRelative(dependency)) {
+			entry.inputs[dependency] = getInputState(dependency)
+		}
+	}
+	h.remember(relative, entry)
+	return entry.result()
+}
+
+// HasDirectoryIndex reports if the directory relative to the markdown folder gets a generated index page
+func (h *OnDemandHandler) HasDirectoryIndex(relative string) bool {
+	SiteNavigation.Refresh(".")
+	directory := SiteNavigation.Tree().Find(relative)
+	return directory != nil && NeedsDirectoryIndex(directory, relative)
+}
+
+// RenderDirectoryIndex generates the index page of a directory without an index.md, it is cheap enough to not be cached
+func (h *OnDemandHandler) RenderDirectoryIndex(relative string) ([]byte, error) {
+	layouts := LayoutFolderState()
+	cssList, err := ReadCSSFileList()
+	if err != nil {
+		return nil, err
+	}
+
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.useSharedInputs(layouts, cssList)
+	tree := SiteNavigation.Tree()
+	directory := tree.Find(relative)
+	if directory == nil {
+		return nil, fs.ErrNotExist
+	}
+	return GenerateDirectoryIndex(tree, directory, relative)
+}
+
+// RenderSearchPage generates the search page, it is cheap enough to not be cached
+func (h *OnDemandHandler) RenderSearchPage() ([]byte, error) {
+	layouts := LayoutFolderState()
+	cssList, err := ReadCSSFileList()
+	if err != nil {
+		return nil, err
+	}
+	SiteNavigation.Refresh(".")
+
+	h.renderMu.Lock()
+	defer h.renderMu.Unlock()
+	h.useSharedInputs(layouts, cssList)
+	return GenerateSearchPage()
+}
+
+// RenderAll brings every page up to date, so the search index covers the whole markdown folder
+func (h *OnDemandHandler) RenderAll() {
+	shared, err := readSharedInputs()
+	if err != nil {
+		log.Printf("While rendering all pages encountered error: %v", err)
+		return
+	}
+	pages := make(map[string]bool)
+	_ = filepath.Walk(AbsolutePath, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if IsLayoutFolder(path, info) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".md") {
+			return nil
+		}
+		relative := mustRelative(path)
+		pages[relative] = true
+		if h.cached(relative).fresh(getInputState(path), shared) {
+			return nil
+		}
+		// broken pages are reported when they are requested
+		_, _ = h.render(relative, shared)
+		return nil
+	})
+	SiteSearch.Keep(pages)
+}
+
+// useSharedInputs prepares the globals used while rendering, it must be called with renderMu held
+func (h *OnDemandHandler) useSharedInputs(layouts string, cssList []string) {
+	if h.layouts != layouts {
+		ResetLayouts()
+		if err := ReloadConfig(); err != nil {
+			log.Printf("While reloading the config file encountered error: %v", err)
+		}
+		h.layouts = layouts
+	}
+	CSSFileList = cssList
+}
+
+// Dependents returns the cached pages including the source. If the source was created or removed and not
+// only written to, the cached pages linking to it are included as well.
+func (h *OnDemandHandler) Dependents(source string, update bool) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	pages := make([]string, 0)
+	for relative, entry := range h.cache {
+		_, included := entry.inputs[source]
+		_, linked := entry.links[source]
+		if included || linked && !update {
+			pages = append(pages, SourcePath(relative))
+		}
+	}
+	slices.Sort(pages)
+	return pages
+}
+
+// NavigationChanged reports if the navigation changed since it was last called
+func (h *OnDemandHandler) NavigationChanged() bool {
+	SiteNavigation.Refresh(".")
+	navigation := SiteNavigation.Version()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	changed := navigation != h.navigation
+	h.navigation = navigation
+	return changed
+}
+
+// report records the warnings of the page and tells the browsers if they changed
+func (h *OnDemandHandler) report(relative string, warnings []Warning) {
+	if SiteWarnings.Set(relative, warnings) && h.Reported != nil {
+		h.Reported()
+	}
+}
+
+func (h *OnDemandHandler) cached(relative string) *cachedPage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cache[relative]
+}
+
+func (h *OnDemandHandler) remember(relative string, entry *cachedPage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cache[relative] = entry
+}
+
+func (h *OnDemandHandler) forget(relative string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.cache, relative)
+}
+
+// fresh reports if the entry was rendered from the source and shared inputs in their current states
+func (entry *cachedPage) fresh(state inputState, shared sharedInputs) bool {
+	return entry != nil && entry.source == state && entry.sharedInputsMatch(shared.layouts, shared.cssList, shared.navigation)
+}
+
+func (entry *cachedPage) sharedInputsMatch(layouts string, cssList []string, navigation int) bool {
+	if entry.layouts != layouts || entry.cssList != strings.Join(cssList, "\n") || entry.navigation != navigation {
+		return false
+	}
+	for input, state := range entry.inputs {
+		if getInputState(input) != state {
+			return false
+		}
+	}
+	for target, exists := range entry.links {
+		if fileExists(target) != exists {
+			return false
+		}
+	}
+	return true
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (entry *cachedPage) withSource(state inputState) *cachedPage {
+	updated := *entry
+	updated.source = state
+	return &updated
+}
+
+func (entry *cachedPage) result() ([]byte, error) {
+	if entry.draft {
+		return nil, fs.ErrNotExist
+	}
+	return entry.html, nil
+}
+
+func mustRelative(path string) string {
+	relative, err := SourceRelative(path)
+	if err != nil {
+		return path
+	}
+	return relative
+}
+
+// LayoutFolderState describes the modification times of all layouts and the config file,
+// so a change to any of them is noticed
+func LayoutFolderState() string {
+	files, _ := filepath.Glob(filepath.Join(AbsolutePath, LayoutFolder, "*.html"))
+	if config := FindConfigFile(); config != "" {
+		files = append(files, config)
+	}
+	var state bytes.Buffer
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		state.WriteString(file + "@" + info.ModTime().String() + "\n")
+	}
+	return state.String()
+}
+
+// ReadCSSFileList returns the stylesheets in the root of the markdown folder
+func ReadCSSFileList() ([]string, error) {
+	entries, err := os.ReadDir(AbsolutePath)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() && IsCSSListEntry(entry.Name()) {
+			result = append(result, entry.Name())
+		}
+	}
+	slices.Sort(result)
+	return result, nil
+}
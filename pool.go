@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*********************************************
+*** FUNCTIONS FOR BUILDING FILES IN PARALLEL ***
+**********************************************/
+
+// BuildWorkers is the number of goroutines converting and copying files at the same time
+var BuildWorkers = GetBuildWorkers()
+
+// GetBuildWorkers reads BUILD_WORKERS and defaults to the number of usable CPUs
+func GetBuildWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("BUILD_WORKERS"))
+	if err != nil || workers < 1 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// BuildStats summarizes a run of BuildFiles
+type BuildStats struct {
+	Pages    int
+	Files    int
+	Failed   int
+	Workers  int
+	Duration time.Duration
+}
+
+func (stats BuildStats) String() string {
+	return fmt.Sprintf("converted %d pages and copied %d files in %s using %d workers (%d failed)",
+		stats.Pages, stats.Files, stats.Duration.Round(time.Millisecond), stats.Workers, stats.Failed)
+}
+
+// BuildFiles runs BuildSourceFile for every path relative to the markdown folder on a bounded
+// pool of goroutines. The returned error joins all failures in the order of the input.
+func BuildFiles(relatives []string) (BuildStats, error) {
+	start := time.Now()
+	stats := BuildStats{Workers: max(1, min(BuildWorkers, len(relatives)))}
+
+	errs := make([]error, len(relatives))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range stats.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = BuildSourceFile(relatives[i])
+			}
+		}()
+	}
+	for i := range relatives {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, relative := range relatives {
+		if errs[i] != nil {
+			stats.Failed++
+		} else if strings.HasSuffix(relative, ".md") {
+			stats.Pages++
+		} else {
+			stats.Files++
+		}
+	}
+	stats.Duration = time.Since(start)
+	return stats, errors.Join(errs...)
+}
@@ -0,0 +1,148 @@
+package reload
+
+import (
+	// Locally injected version of https://www.github.com/gorilla/websocket v1.5.3
+	"markdown-server/websocket"
+	"sync"
+	"time"
+)
+
+/*************************************************
+*** FUNCTIONS AND DEFINITIONS FOR CONNECTIONS ***
+**************************************************/
+
+// hub keeps track of the open websocket connections, every message is sent to all of them
+type hub struct {
+	mu      sync.Mutex
+	clients map[*client]bool
+	closed  bool
+	// greeting is sent to every new connection, so it shows the current problems
+	greeting []byte
+	// active counts the connections that are still being served, so closing can wait for them
+	active sync.WaitGroup
+}
+
+// client is a single websocket connection of a browser
+type client struct {
+	// send delivers the messages to the connection, it is closed when the hub shuts down
+	send chan []byte
+	// dead is closed once the browser stops answering or closes the connection
+	dead chan struct{}
+}
+
+// clientBuffer is the number of messages a connection can fall behind before it is dropped
+const clientBuffer = 16
+
+func newHub() *hub {
+	return &hub{clients: make(map[*client]bool)}
+}
+
+// register adds a connection to the hub, or returns nil if the hub is already closed
+func (h *hub) register() *client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil
+	}
+	c := &client{send: make(chan []byte, clientBuffer), dead: make(chan struct{})}
+	if h.greeting != nil {
+		c.send <- h.greeting
+	}
+	h.clients[c] = true
+	h.active.Add(1)
+	return c
+}
+
+// unregister removes the connection once it is no longer served
+func (h *hub) unregister(c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.send)
+	}
+	h.active.Done()
+}
+
+// broadcast queues the message for every connection, connections too far behind are dropped.
+// The greeting replaces the one sent to new connections.
+func (h *hub) broadcast(message []byte, greeting []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.greeting = greeting
+	for c := range h.clients {
+		select {
+		case c.send <- message:
+		default:
+			delete(h.clients, c)
+			close(c.send)
+		}
+	}
+}
+
+// close tells every connection to shut down and waits until all of them are closed
+func (h *hub) close() {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		for c := range h.clients {
+			delete(h.clients, c)
+			close(c.send)
+		}
+	}
+	h.mu.Unlock()
+	h.active.Wait()
+}
+
+// readLoop answers the pings of the browser and notices when it is gone. Browsers do not send messages,
+// so everything read only keeps the connection alive.
+func (reload *Reloader) readLoop(conn *websocket.Conn, c *client) {
+	defer close(c.dead)
+	conn.SetReadLimit(512)
+	extend := func() {
+		_ = conn.SetReadDeadline(time.Now().Add(reload.PongWait))
+	}
+	extend()
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		extend()
+		// a failed pong shows up as a failed read
+		_ = conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(reload.WriteWait))
+		return nil
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		extend()
+	}
+}
+
+// writeLoop sends the messages and the heartbeats to the browser until it is gone or the hub drops it
+func (reload *Reloader) writeLoop(conn *websocket.Conn, c *client) {
+	ticker := time.NewTicker(reload.PingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+				_ = conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(reload.WriteWait))
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(reload.WriteWait))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(reload.WriteWait)); err != nil {
+				return
+			}
+		case <-c.dead:
+			return
+		}
+	}
+}
@@ -1,14 +1,17 @@
 package reload
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	// Locally injected version of https://www.github.com/gorilla/websocket v1.5.3
 	"markdown-server/websocket"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 /***********************************************
@@ -18,11 +21,23 @@ import (
 type Reloader struct {
 	// OnReload will be called after a file changes, but before the browser reloads.
 	OnReload func(path string, update bool)
+	// Describe is called after OnReload and tells the browsers what the changed file means for them.
+	// By default the changed file is described by its extension and every page reloads.
+	Describe func(path string, update bool) Change
+	// Problems returns the errors and warnings of the build, they are shown in the browser until they are gone.
+	// It is called after every burst of changes and by Report.
+	Problems func() []Problem
 	// directories to recursively watch
 	directories []string
 	// Endpoint defines what path the WebSocket connection is formed over.
 	// It is set to "/reload_ws" by default.
 	Endpoint string
+	// EventsEndpoint defines the path of the Server-Sent Events stream, which is used when a websocket can not
+	// be opened. It is set to "/reload-events" by default.
+	EventsEndpoint string
+	// Transport selects how the browsers listen for reloads: TransportAuto tries a websocket first and falls
+	// back to Server-Sent Events, TransportWebSocket and TransportSSE only use one of them.
+	Transport string
 	// Deprecated: see DisableCaching instead.
 	AllowCaching bool
 	// DisableCaching is set to true by default. Writes a "Cache-Control=no-cache" header on each response.
@@ -42,9 +57,62 @@ type Reloader struct {
 	// Used to upgrade connections to Websocket connections
 	Upgrader websocket.Upgrader
 
-	// Used to trigger a reload on all websocket connections at once
-	cond           *sync.Cond
+	// PingPeriod is how often a heartbeat is sent to every browser
+	PingPeriod time.Duration
+	// PongWait is how long a browser may stay silent before its connection counts as dead.
+	// It must be longer than PingPeriod.
+	PongWait time.Duration
+	// WriteWait is how long writing a single message may take
+	WriteWait time.Duration
+
+	// Holds the websocket connections, every reload is sent to all of them at once
+	hub            *hub
 	startedWatcher bool
+	// stop is closed by Close to end watching the directories
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// Transports the injected script can listen for reloads with
+const (
+	TransportAuto      = "auto"
+	TransportWebSocket = "websocket"
+	TransportSSE       = "sse"
+)
+
+// Kinds of changed files, a browser swaps changed stylesheets in place and reloads for everything else
+const (
+	KindPage  = "page"
+	KindCSS   = "css"
+	KindAsset = "asset"
+)
+
+// Change describes a changed file for the browsers
+type Change struct {
+	// Path is the URL path the changed file is served at
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Pages are the URL paths of the pages that have to reload
+	Pages []string `json:"pages"`
+	// All reloads every page, like after a change to a layout, even if the change is a stylesheet
+	All bool `json:"all"`
+}
+
+// Problem is an error or a warning of the build shown in the browser
+type Problem struct {
+	File string `json:"file"`
+	// Line is the line in the file, or 0 if it is unknown
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+	Error   bool   `json:"error"`
+}
+
+// Message is sent as JSON to the browsers. A message of the type "reload" follows a burst of changes,
+// one of the type "problems" only updates the problems. Every message holds all current problems.
+type Message struct {
+	Type     string    `json:"type"`
+	Changes  []Change  `json:"changes"`
+	Problems []Problem `json:"problems"`
 }
 
 // New returns a new Reloader with the provided directories.
@@ -52,13 +120,20 @@ func New(directories ...string) *Reloader {
 	return &Reloader{
 		directories:    directories,
 		Endpoint:       "/reload-ws",
+		EventsEndpoint: "/reload-events",
+		Transport:      TransportAuto,
 		ErrorLog:       log.New(os.Stderr, "Reload: ", log.Lmsgprefix|log.Ltime),
 		DebugLog:       log.New(os.Stdout, "Reload: ", log.Lmsgprefix|log.Ltime),
 		Upgrader:       websocket.Upgrader{},
 		DisableCaching: true,
 
+		PingPeriod: 30 * time.Second,
+		PongWait:   60 * time.Second,
+		WriteWait:  10 * time.Second,
+
 		startedWatcher: false,
-		cond:           sync.NewCond(&sync.Mutex{}),
+		hub:            newHub(),
+		stop:           make(chan struct{}),
 	}
 }
 
@@ -69,7 +144,7 @@ func (reload *Reloader) Handle(next http.Handler) http.Handler {
 		go reload.WatchDirectories()
 		reload.startedWatcher = true
 	}
-	scriptToInject := InjectedScript(reload.Endpoint)
+	scriptToInject := InjectedScript(reload.Endpoint, reload.EventsEndpoint, reload.Transport)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Endpoint == "/reload_ws" by default
@@ -77,6 +152,10 @@ func (reload *Reloader) Handle(next http.Handler) http.Handler {
 			reload.ServeWS(w, r)
 			return
 		}
+		if r.URL.Path == reload.EventsEndpoint {
+			reload.ServeSSE(w, r)
+			return
+		}
 		if dest := r.Header.Get("Sec-Fetch-Dest"); dest != "" && dest != "document" {
 			// Only requests with Sec-Fetch-Dest == "document" will have HTML document responses.
 			next.ServeHTTP(w, r)
@@ -116,9 +195,8 @@ func (reload *Reloader) Handle(next http.Handler) http.Handler {
 	})
 }
 
-// ServeWS is the default websocket endpoint.
-// Implementing your own is easy enough if you
-// don't want to use 'gorilla/websocket'
+// ServeWS is the default websocket endpoint. The connection stays open across reloads,
+// heartbeats detect browsers that are gone.
 func (reload *Reloader) ServeWS(w http.ResponseWriter, r *http.Request) {
 	conn, err := reload.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -126,40 +204,272 @@ func (reload *Reloader) ServeWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Block here until next reload event
-	reload.Wait()
+	c := reload.hub.register()
+	if c == nil {
+		closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+		_ = conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(reload.WriteWait))
+		_ = conn.Close()
+		return
+	}
 
-	_ = conn.WriteMessage(websocket.TextMessage, []byte("reload"))
+	go reload.readLoop(conn, c)
+	reload.writeLoop(conn, c)
+	// closing the connection ends the read loop, the hub only counts the connection as closed after that
 	_ = conn.Close()
+	<-c.dead
+	reload.hub.unregister(c)
+}
+
+// ServeSSE is the Server-Sent Events endpoint for browsers that can not open a websocket, for example behind
+// a proxy breaking the upgrade. It receives the same messages as the websockets.
+func (reload *Reloader) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	c := reload.hub.register()
+	if c == nil {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer reload.hub.unregister(c)
+
+	controller := http.NewResponseController(w)
+	// the stream stays open for as long as the browser shows the page
+	_ = controller.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprint(w, "retry: 1000\n\n")
+	if err := controller.Flush(); err != nil {
+		reload.logError("ServeSSE error: %s\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(reload.PingPeriod)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case message, ok := <-c.send:
+			if !ok {
+				return
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", message)
+		case <-ticker.C:
+			// a comment is ignored by the browser, writing it notices a connection that is gone
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
 }
 
-func (reload *Reloader) Wait() {
-	reload.cond.L.Lock()
-	reload.cond.Wait()
-	reload.cond.L.Unlock()
+// Close stops watching the directories, tells every browser that the server goes away and waits until
+// all connections are closed. New connections are refused afterwards, the browsers reconnect once the
+// server is back.
+func (reload *Reloader) Close() {
+	reload.closeOnce.Do(func() {
+		close(reload.stop)
+	})
+	reload.hub.close()
+}
+
+// Broadcast sends the changes and the current problems to every browser
+func (reload *Reloader) Broadcast(changes []Change) {
+	reload.send(Message{Type: "reload", Changes: changes, Problems: reload.problems()})
+}
+
+// Report sends the current problems to every browser without reloading, like after the first build
+func (reload *Reloader) Report() {
+	reload.send(Message{Type: "problems", Changes: []Change{}, Problems: reload.problems()})
+}
+
+func (reload *Reloader) problems() []Problem {
+	if reload.Problems == nil {
+		return []Problem{}
+	}
+	return reload.Problems()
 }
 
-func InjectedScript(endpoint string) string {
+// send delivers the message to every browser, browsers connecting later receive the problems of it
+func (reload *Reloader) send(message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		reload.logError("Broadcast error: %s\n", err)
+		return
+	}
+	greeting, err := json.Marshal(Message{Type: "problems", Changes: []Change{}, Problems: message.Problems})
+	if err != nil {
+		reload.logError("Broadcast error: %s\n", err)
+		return
+	}
+	reload.hub.broadcast(data, greeting)
+}
+
+// describe returns the change for the path, using Describe if it is set
+func (reload *Reloader) describe(path string, update bool) Change {
+	if reload.Describe != nil {
+		return reload.Describe(path, update)
+	}
+	change := Change{Path: "/" + filepath.ToSlash(filepath.Base(path)), Kind: KindAsset, All: true}
+	for _, directory := range reload.directories {
+		absolute, err := filepath.Abs(directory)
+		if err != nil {
+			continue
+		}
+		if relative, err := filepath.Rel(absolute, path); err == nil && !strings.HasPrefix(relative, "..") {
+			change.Path = "/" + filepath.ToSlash(relative)
+			break
+		}
+	}
+	switch filepath.Ext(path) {
+	case ".css":
+		change.Kind = KindCSS
+	case ".md", ".html":
+		change.Kind = KindPage
+	}
+	return change
+}
+
+// InjectedScript swaps changed stylesheets in place and only reloads the page if it is affected by a change.
+// The scroll position is kept across reloads. With TransportAuto a websocket that never opens is replaced
+// by the Server-Sent Events stream.
+func InjectedScript(endpoint string, eventsEndpoint string, transport string) string {
 	return fmt.Sprintf(`
 <script>
-	function retry() {
-	  setTimeout(() => listen(true), 1000)
-	}
-	function listen(isRetry) {
-      let protocol = location.protocol === "https:" ? "wss://" : "ws://"
-	  let ws = new WebSocket(protocol + location.host + "%s")
-	  if(isRetry) {
-	    ws.onopen = () => window.location.reload()
+	(function() {
+	  const scrollKey = "reload-scroll:" + location.pathname
+	  const scroll = sessionStorage.getItem(scrollKey)
+	  if(scroll !== null) {
+	    sessionStorage.removeItem(scrollKey)
+	    window.addEventListener("load", () => window.scrollTo(0, Number(scroll)))
 	  }
-	  ws.onmessage = function(msg) {
-	    if(msg.data === "reload") {
-	      window.location.reload()
+	  function normalize(path) {
+	    return decodeURI(path).replace(/index\.html$/, "")
+	  }
+	  function reloadPage() {
+	    sessionStorage.setItem(scrollKey, String(window.scrollY))
+	    window.location.reload()
+	  }
+	  function swapStylesheet(path) {
+	    let found = false
+	    for(const link of document.querySelectorAll('link[rel="stylesheet"]')) {
+	      const url = new URL(link.href)
+	      if(url.host !== location.host || normalize(url.pathname) !== normalize(path)) {
+	        continue
+	      }
+	      found = true
+	      url.searchParams.set("reload", Date.now())
+	      const swapped = link.cloneNode()
+	      swapped.href = url.href
+	      swapped.onload = () => link.remove()
+	      link.after(swapped)
 	    }
+	    return found
 	  }
-	  ws.onclose = retry
-	}
-	listen(false)
-</script>`, endpoint)
+	  function affectsPage(change) {
+	    const current = normalize(location.pathname)
+	    return change.all || normalize(change.path) === current ||
+	      (change.pages || []).some(page => normalize(page) === current)
+	  }
+	  function showProblems(problems) {
+	    let overlay = document.getElementById("reload-problems")
+	    if(problems.length === 0) {
+	      if(overlay) {
+	        overlay.remove()
+	      }
+	      return
+	    }
+	    if(!overlay) {
+	      overlay = document.createElement("div")
+	      overlay.id = "reload-problems"
+	      overlay.style.cssText = "position:fixed;left:0;right:0;bottom:0;max-height:50vh;overflow:auto;z-index:2147483647;" +
+	        "margin:0;padding:0.5em 1em;background:#1e1e1e;color:#eee;font:13px/1.5 monospace;box-shadow:0 -2px 8px rgba(0,0,0,0.5)"
+	      document.body.appendChild(overlay)
+	    }
+	    overlay.replaceChildren()
+	    const close = document.createElement("button")
+	    close.textContent = "\u00d7"
+	    close.title = "Hide until the next build"
+	    close.style.cssText = "float:right;background:none;border:none;color:inherit;font-size:18px;cursor:pointer"
+	    close.onclick = () => overlay.remove()
+	    overlay.appendChild(close)
+	    for(const problem of problems) {
+	      const line = document.createElement("div")
+	      line.style.color = problem.error ? "#ff6b6b" : "#ffd166"
+	      line.textContent = (problem.error ? "error " : "warning ") + problem.file +
+	        (problem.line ? ":" + problem.line : "") + ": " + problem.message
+	      overlay.appendChild(line)
+	    }
+	  }
+	  function apply(message) {
+	    showProblems(message.problems || [])
+	    if(message.type !== "reload") {
+	      return
+	    }
+	    let reload = false
+	    for(const change of message.changes || []) {
+	      if(change.kind === "css" && !change.all && swapStylesheet(change.path)) {
+	        continue
+	      }
+	      reload = reload || affectsPage(change)
+	    }
+	    if(reload) {
+	      reloadPage()
+	    }
+	  }
+	  const transport = "%s"
+	  let opened = false
+	  function retry() {
+	    setTimeout(() => listen(true), 1000)
+	  }
+	  function listen(isRetry) {
+	    let protocol = location.protocol === "https:" ? "wss://" : "ws://"
+	    let ws = new WebSocket(protocol + location.host + "%s")
+	    ws.onopen = function() {
+	      if(isRetry && opened) {
+	        reloadPage()
+	      }
+	      opened = true
+	    }
+	    ws.onmessage = function(msg) {
+	      apply(JSON.parse(msg.data))
+	    }
+	    ws.onclose = function() {
+	      if(!opened && transport === "auto") {
+	        listenEvents()
+	      } else {
+	        retry()
+	      }
+	    }
+	  }
+	  function listenEvents() {
+	    // the browser reconnects the stream itself, a reconnect after an error means the server restarted
+	    let failed = false
+	    let events = new EventSource("%s")
+	    events.onopen = function() {
+	      if(failed) {
+	        reloadPage()
+	      }
+	    }
+	    events.onerror = function() {
+	      failed = true
+	    }
+	    events.onmessage = function(msg) {
+	      apply(JSON.parse(msg.data))
+	    }
+	  }
+	  if(transport === "sse") {
+	    listenEvents()
+	  } else {
+	    listen(false)
+	  }
+	})()
+</script>`, transport, endpoint, eventsEndpoint)
 }
 
 func (reload *Reloader) logDebug(format string, v ...any) {
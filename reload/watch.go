@@ -4,7 +4,6 @@ import (
 	"errors"
 	"io/fs"
 	"markdown-server/fsnotify"
-	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -44,6 +43,7 @@ func (reload *Reloader) WatchDirectories() {
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
 		reload.logError("error initializing fsnotify watcher: %s\n", err)
+		return
 	}
 
 	defer w.Close()
@@ -75,18 +75,56 @@ func (reload *Reloader) WatchDirectories() {
 
 	debounce := NewDebouncer(100 * time.Millisecond)
 
-	callback := func(path string, update bool) func() {
-		return func() {
-			reload.logDebug("Edit %s\n", path)
+	// Events are collected until the debouncer fires, so that no change
+	// within a burst is lost when OnReload only handles single paths
+	var pendingMu, flushMu sync.Mutex
+	pending := make([]pendingChange, 0)
+
+	flush := func() {
+		select {
+		case <-reload.stop:
+			// changes after Close are not built anymore
+			return
+		default:
+		}
+		pendingMu.Lock()
+		changes := pending
+		pending = make([]pendingChange, 0)
+		pendingMu.Unlock()
+
+		flushMu.Lock()
+		defer flushMu.Unlock()
+		described := make([]Change, 0, len(changes))
+		for _, change := range changes {
+			reload.logDebug("Edit %s\n", change.path)
 			if reload.OnReload != nil {
-				reload.OnReload(path, update)
+				reload.OnReload(change.path, change.update)
 			}
-			reload.cond.Broadcast()
+			described = append(described, reload.describe(change.path, change.update))
 		}
+		reload.Broadcast(described)
+	}
+
+	queue := func(path string, update bool) {
+		pendingMu.Lock()
+		defer pendingMu.Unlock()
+		for i := range pending {
+			if pending[i].path == path {
+				// only a path that was exclusively written to counts as an update
+				pending[i].update = pending[i].update && update
+				debounce(flush)
+				return
+			}
+		}
+		pending = append(pending, pendingChange{path: path, update: update})
+		debounce(flush)
 	}
 
 	for {
 		select {
+		case <-reload.stop:
+			reload.logDebug("stopped watching %s\n", strings.Join(reload.directories, ","))
+			return
 		case err := <-w.Errors:
 			reload.logError("error watching: %s \n", err)
 		case e := <-w.Events:
@@ -97,17 +135,18 @@ func (reload *Reloader) WatchDirectories() {
 			switch {
 			case e.Has(fsnotify.Create):
 				reload.logDebug("Create %s\n", e.Name)
-				dir := filepath.Dir(e.Name)
-				// Watch any created directory
-				if err := w.Add(dir); err != nil {
-					reload.logError("error watching %s: %s\n", e.Name, err)
-					continue
+				// Watch any created directory, including directories moved in with content
+				directories, _ := recursiveWalk(e.Name)
+				for _, dir := range directories {
+					if err := w.Add(dir); err != nil {
+						reload.logError("error watching %s: %s\n", dir, err)
+					}
 				}
-				debounce(callback(path.Base(e.Name), false))
+				queue(e.Name, false)
 
 			case e.Has(fsnotify.Write):
 				reload.logDebug("Write %s\n", e.Name)
-				debounce(callback(path.Base(e.Name), true))
+				queue(e.Name, true)
 
 			case e.Has(fsnotify.Rename), e.Has(fsnotify.Remove):
 				reload.logDebug("Remove or Rename %s\n", e.Name)
@@ -117,12 +156,17 @@ func (reload *Reloader) WatchDirectories() {
 					_ = w.Remove(v)
 				}
 				_ = w.Remove(e.Name)
-				debounce(callback(path.Base(e.Name), false))
+				queue(e.Name, false)
 			}
 		}
 	}
 }
 
+type pendingChange struct {
+	path   string
+	update bool
+}
+
 func recursiveWalk(path string) ([]string, error) {
 	var res []string
 	err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
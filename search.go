@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"markdown-server/markdown/ast"
+)
+
+/*********************************************
+*** FUNCTIONS FOR THE FULL-TEXT SEARCH ***
+**********************************************/
+
+// SearchIndexFile is the inverted index written next to the generated pages
+const SearchIndexFile = "search-index.json"
+
+// SearchPage is the generated page querying the search endpoint, unless a markdown file generates it
+const SearchPage = "search.html"
+
+// HeadingBoost is how much more a term counts in a heading than in the text of a section
+const HeadingBoost = 3
+
+const DefaultSearchLimit = 20
+const MaxSearchLimit = 100
+
+// SearchSection is the plain text of a page from one heading up to the next
+type SearchSection struct {
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Heading string `json:"heading,omitempty"`
+	Text    string `json:"text"`
+}
+
+// Posting records how often a term appears in a section, heading occurrences are boosted
+type Posting struct {
+	Section int `json:"section"`
+	Count   int `json:"count"`
+}
+
+// SearchData is the inverted index as it is written to SearchIndexFile
+type SearchData struct {
+	Sections []SearchSection      `json:"sections"`
+	Terms    map[string][]Posting `json:"terms"`
+	// sortedTerms are the keys of Terms for prefix matches
+	sortedTerms []string
+}
+
+// SearchIndex holds the sections of every published page by its path relative to the markdown folder
+type SearchIndex struct {
+	mu    sync.Mutex
+	pages map[string][]SearchSection
+	// data is built on first use after a change
+	data *SearchData
+}
+
+// SiteSearch is the search index of the current build, it is replaced on every full rebuild
+var SiteSearch = NewSearchIndex()
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{pages: make(map[string][]SearchSection)}
+}
+
+func (index *SearchIndex) Set(relative string, sections []SearchSection) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.pages[relative] = sections
+	index.data = nil
+}
+
+// Remove deletes the sections of the page or directory, the index is encoded again on the next use
+func (index *SearchIndex) Remove(relative string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if RemoveInside(index.pages, relative) {
+		index.data = nil
+	}
+}
+
+// Keep deletes every page not in the list
+func (index *SearchIndex) Keep(relatives map[string]bool) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for page := range index.pages {
+		if !relatives[page] {
+			delete(index.pages, page)
+			index.data = nil
+		}
+	}
+}
+
+// Data returns the inverted index of all pages, it is shared and must not be modified
+func (index *SearchIndex) Data() *SearchData {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	if index.data != nil {
+		return index.data
+	}
+
+	data := &SearchData{Sections: make([]SearchSection, 0), Terms: make(map[string][]Posting)}
+	for _, relative := range SortedKeys(index.pages) {
+		for _, section := range index.pages[relative] {
+			counts := make(map[string]int)
+			for _, term := range Tokenize(section.Heading) {
+				counts[term] += HeadingBoost
+			}
+			for _, term := range Tokenize(section.Text) {
+				counts[term]++
+			}
+			for term, count := range counts {
+				data.Terms[term] = append(data.Terms[term], Posting{Section: len(data.Sections), Count: count})
+			}
+			data.Sections = append(data.Sections, section)
+		}
+	}
+	data.sortedTerms = SortedKeys(data.Terms)
+	index.data = data
+	return data
+}
+
+// Tokenize splits text into lower case terms of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchResult is a single ranked match of a query
+type SearchResult struct {
+	URL     string  `json:"url"`
+	Title   string  `json:"title"`
+	Heading string  `json:"heading,omitempty"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// Search returns the sections containing every term of the query ranked by tf-idf.
+// The last term also matches longer terms, so results appear while typing.
+func (data *SearchData) Search(query string, limit int) []SearchResult {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return []SearchResult{}
+	}
+
+	var scores map[int]float64
+	for i, term := range terms {
+		matches := []string{term}
+		if i == len(terms)-1 {
+			matches = data.prefixed(term)
+		}
+		termScores := make(map[int]float64)
+		for _, match := range matches {
+			postings := data.Terms[match]
+			idf := math.Log(1 + float64(len(data.Sections))/float64(len(postings)))
+			for _, posting := range postings {
+				termScores[posting.Section] += float64(posting.Count) * idf
+			}
+		}
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for section, score := range scores {
+			if termScore, ok := termScores[section]; ok {
+				scores[section] = score + termScore
+			} else {
+				delete(scores, section)
+			}
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for section, score := range scores {
+		s := data.Sections[section]
+		results = append(results, SearchResult{
+			URL:     s.URL,
+			Title:   s.Title,
+			Heading: s.Heading,
+			Snippet: Snippet(s.Text, terms),
+			Score:   math.Round(score*1000) / 1000,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].URL < results[j].URL
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// prefixed returns all terms of the index starting with the prefix
+func (data *SearchData) prefixed(prefix string) []string {
+	start := sort.SearchStrings(data.sortedTerms, prefix)
+	end := start
+	for end < len(data.sortedTerms) && strings.HasPrefix(data.sortedTerms[end], prefix) {
+		end++
+	}
+	return data.sortedTerms[start:end]
+}
+
+// SnippetLength is the number of characters of a section shown with a result
+const SnippetLength = 160
+
+// Snippet returns the part of the text around the first occurrence of any of the terms
+func Snippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		// lower casing changed the length, positions would not match
+		lower = runes
+	}
+
+	position := -1
+	for _, term := range terms {
+		if found := indexRunes(lower, []rune(term)); found != -1 && (position == -1 || found < position) {
+			position = found
+		}
+	}
+	start := max(0, position-SnippetLength/4)
+	end := min(len(runes), start+SnippetLength)
+	// cut at word boundaries
+	for start > 0 && start < end && !unicode.IsSpace(runes[start-1]) {
+		start++
+	}
+	for end < len(runes) && end > start && !unicode.IsSpace(runes[end]) {
+		end--
+	}
+
+	snippet := strings.TrimSpace(string(runes[start:end]))
+	if start > 0 {
+		snippet = "… " + snippet
+	}
+	if end < len(runes) {
+		snippet += " …"
+	}
+	return snippet
+}
+
+func indexRunes(text []rune, term []rune) int {
+	for i := 0; i+len(term) <= len(text); i++ {
+		if string(text[i:i+len(term)]) == string(term) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ExtractSearchSections collects the plain text of the page, split at every heading.
+// Url is where the page is served, the sections link to the anchors of their headings.
+func ExtractSearchSections(doc ast.Node, title string, url string) []SearchSection {
+	sections := make([]SearchSection, 0)
+	current := SearchSection{URL: url, Title: title}
+	var heading, text strings.Builder
+	inHeading := false
+
+	finish := func() {
+		current.Heading = strings.Join(strings.Fields(heading.String()), " ")
+		current.Text = strings.Join(strings.Fields(text.String()), " ")
+		if current.Heading != "" || current.Text != "" {
+			sections = append(sections, current)
+		}
+		heading.Reset()
+		text.Reset()
+	}
+
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Heading:
+			if entering {
+				finish()
+				current = SearchSection{URL: url, Title: title}
+				if n.HeadingID != "" {
+					current.URL = url + "#" + n.HeadingID
+				}
+			}
+			inHeading = entering
+			return ast.GoToNext
+		case *ast.Text, *ast.Code, *ast.CodeBlock:
+			target := &text
+			if inHeading {
+				target = &heading
+			}
+			target.Write(node.AsLeaf().Literal)
+			target.WriteByte(' ')
+		case *ast.Paragraph, *ast.ListItem, *ast.TableCell, *ast.Hardbreak, *ast.Softbreak:
+			text.WriteByte(' ')
+		}
+		return ast.GoToNext
+	})
+	finish()
+	return sections
+}
+
+// SearchHandler answers "/search?q=" with the ranked results as JSON
+type SearchHandler struct {
+	// Prepare brings the search index up to date before a query, it is only needed in on demand mode
+	Prepare func()
+}
+
+func (h *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, MaxSearchLimit)
+	}
+	if h.Prepare != nil {
+		h.Prepare()
+	}
+
+	query := r.URL.Query().Get("q")
+	results := SiteSearch.Data().Search(query, limit)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(struct {
+		Query   string         `json:"query"`
+		Results []SearchResult `json:"results"`
+	}{query, results})
+}
+
+// SearchIndexJSON encodes the inverted index of all pages
+func SearchIndexJSON() ([]byte, error) {
+	return json.Marshal(SiteSearch.Data())
+}
+
+var SearchPageTemplate = template.Must(template.New("search").Parse(`<h1>Suche</h1>
+<form class="search" action="{{.}}" method="get">
+<input type="search" name="q" autofocus>
+<button type="submit">Suchen</button>
+</form>
+<ol class="search-results"></ol>
+<script>
+(function () {
+  const query = new URLSearchParams(location.search).get("q") || "";
+  const form = document.querySelector("form.search");
+  const list = document.querySelector("ol.search-results");
+  form.q.value = query;
+  if (query === "") return;
+  fetch("/search?q=" + encodeURIComponent(query))
+    .then(function (response) { return response.json(); })
+    .then(function (data) {
+      if (data.results.length === 0) {
+        list.insertAdjacentHTML("beforebegin", "<p>Keine Treffer</p>");
+        return;
+      }
+      data.results.forEach(function (result) {
+        const item = document.createElement("li");
+        const link = document.createElement("a");
+        link.href = result.url;
+        link.textContent = result.heading ? result.title + " › " + result.heading : result.title;
+        const snippet = document.createElement("p");
+        snippet.textContent = result.snippet;
+        item.append(link, snippet);
+        list.append(item);
+      });
+    });
+})();
+</script>
+`))
+
+// GenerateSearchPage renders the search page through the default layout
+func GenerateSearchPage() ([]byte, error) {
+	var body bytes.Buffer
+	err := SearchPageTemplate.Execute(&body, OutputURL(SearchPage))
+	if err != nil {
+		return nil, err
+	}
+	meta := PageMeta{Title: "Suche", Lang: DefaultLang, Custom: map[string]any{}}
+	return RenderLayout(PageData{
+		Title:      meta.Title,
+		Meta:       meta,
+		Body:       template.HTML(body.String()),
+		CSSFiles:   PageStylesheets(&GetConfig().Page),
+		Navigation: NavigationHTML(SiteNavigation.Tree(), ""),
+	})
+}
+
+// HasSearchPage reports if the search page is generated, a markdown file generating it takes precedence
+func HasSearchPage() bool {
+	_, exists := SourceForOutput(SearchPage)
+	return !exists
+}
+
+// WriteSearchIndex writes the inverted index of the current build into the target folder
+func WriteSearchIndex() error {
+	data, err := SearchIndexJSON()
+	if err != nil {
+		return err
+	}
+	return WriteTarget(filepath.Join(TargetFolder, SearchIndexFile), data)
+}
+
+// WriteSearchPage writes the search page into the target folder
+func WriteSearchPage() error {
+	if !HasSearchPage() {
+		return nil
+	}
+	page, err := GenerateSearchPage()
+	if err != nil {
+		return err
+	}
+	return WriteTarget(filepath.Join(TargetFolder, SearchPage), page)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"markdown-server/reload"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Address is the address the server listens on, an empty one listens on port 80 of every interface
+var Address = os.Getenv("ADDRESS")
+
+// HotReload reloads the browser whenever the markdown folder changes
+var HotReload = os.Getenv("HOT_RELOAD") != ""
+
+func StartServingGeneratedFiles() {
+	fileSystem := GetContentHandler()
+	search := &SearchHandler{}
+	onDemand, _ := fileSystem.(*OnDemandHandler)
+	if onDemand != nil {
+		search.Prepare = onDemand.RenderAll
+	}
+	http.Handle("GET /search", search)
+
+	settings := GetConfig().Server
+	server := &http.Server{
+		Addr:         Address,
+		ReadTimeout:  settings.ReadTimeout,
+		WriteTimeout: settings.WriteTimeout,
+		IdleTimeout:  settings.IdleTimeout,
+	}
+	var reloader *reload.Reloader
+	if HotReload {
+		reloader = NewReloader(onDemand)
+		http.Handle("GET /", reloader.Handle(fileSystem))
+	} else {
+		http.Handle("GET /", fileSystem)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		// a second signal stops the process right away
+		stop()
+		log.Println("Stopping server")
+		ShutdownServer(server, reloader, settings.ShutdownTimeout)
+	}()
+
+	log.Println("Starting server")
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("HTTP server error: %v", err)
+	}
+	<-stopped
+	log.Println("Server stopped")
+}
+
+// ShutdownServer lets open requests finish and closes the reload connections and the watcher.
+// Whatever is still open after the timeout is closed forcefully.
+func ShutdownServer(server *http.Server, reloader *reload.Reloader, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// the reload websockets are hijacked connections, shutting down the server does not wait for them
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		if reloader != nil {
+			reloader.Close()
+		}
+	}()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("While stopping the server encountered error: %v", err)
+		_ = server.Close()
+	}
+	select {
+	case <-reloaded:
+	case <-ctx.Done():
+		log.Println("Reload connections were not closed in time")
+	}
+}
+
+// GetContentHandler serves the target folder, or renders the markdown folder on request in on demand mode
+func GetContentHandler() http.Handler {
+	if OnDemand {
+		log.Println("Rendering markdown files on demand")
+		return NewOnDemandHandler()
+	}
+	return http.FileServer(http.Dir(TargetFolder))
+}
+
+// NewReloader watches the markdown folder, in on demand mode the handler is given and renders the changes itself
+func NewReloader(onDemand *OnDemandHandler) *reload.Reloader {
+	reloader := reload.New(FullPath)
+	reloader.DebugLog = nil
+	reloader.Transport = GetConfig().Server.ReloadTransport
+	reloader.Problems = BuildProblems
+	defer reloader.Report()
+	if onDemand != nil {
+		// the on demand handler notices changes itself, the browsers only have to reload the pages showing them
+		onDemand.Reported = reloader.Report
+		reloader.Describe = func(path string, update bool) reload.Change {
+			return DescribeChange(path, update, onDemand.NavigationChanged(), onDemand.Dependents)
+		}
+		return reloader
+	}
+	// navigationChanged is only used by the watcher, which handles one change after another
+	navigationChanged := false
+	reloader.Describe = func(path string, update bool) reload.Change {
+		return DescribeChange(path, update, navigationChanged, GraphDependents)
+	}
+	reloader.OnReload = func(path string, update bool) {
+		fmt.Printf("Regenerated Targets of '%s'\n", path)
+		navigation := SiteNavigation.Version()
+		defer func() {
+			navigationChanged = navigation != SiteNavigation.Version()
+		}()
+		if err := RebuildSource(path, update); err != nil {
+			log.Printf("While regenerating '%s' encountered error: %v", path, err)
+		}
+		if err := WriteSearchIndex(); err != nil {
+			log.Printf("While writing the search index encountered error: %v", err)
+		}
+		LogWarnings()
+		LogLinkProblems()
+	}
+	return reloader
+}
+
+// GraphDependents returns the pages including the source. If the source was created or removed and not only
+// written to, the pages linking to it are included as well, like RebuildSource rebuilds them.
+func GraphDependents(source string, update bool) []string {
+	pages := Graph.Dependents(source)
+	if !update {
+		pages = slices.Concat(pages, Graph.Dependents(LinkDependency(source)))
+	}
+	return pages
+}
+
+// BuildProblems returns the failed pages and warnings of the current build for the browsers
+func BuildProblems() []reload.Problem {
+	warnings := SiteWarnings.All()
+	problems := make([]reload.Problem, 0, len(warnings))
+	for _, warning := range warnings {
+		problems = append(problems, reload.Problem{File: mustRelative(warning.File), Line: warning.Line, Message: warning.Message, Error: warning.Error})
+	}
+	return problems
+}
+
+// DescribeChange tells the browsers which pages show the changed source path. Every page is affected if
+// the navigation changed or the path is a layout, the config file, a directory or a stylesheet linked
+// in every page that was created or removed. Otherwise the pages are the dependents of the source.
+func DescribeChange(path string, update bool, reloadAll bool, dependents func(source string, update bool) []string) reload.Change {
+	relative, err := SourceRelative(path)
+	if err != nil {
+		return reload.Change{Path: "/", Kind: reload.KindAsset, All: true}
+	}
+	change := reload.Change{Path: OutputURL(OutputRelative(relative)), Kind: reload.KindAsset}
+	switch {
+	case strings.HasSuffix(relative, ".md"):
+		change.Kind = reload.KindPage
+	case strings.HasSuffix(relative, ".css"):
+		change.Kind = reload.KindCSS
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		reloadAll = true
+	}
+	if IsCSSListEntry(relative) && !update {
+		reloadAll = true
+	}
+	if reloadAll || IsLayoutSource(relative) || IsConfigSource(relative) {
+		change.All = true
+		return change
+	}
+
+	pages := dependents(SourcePath(relative), update)
+	change.Pages = make([]string, 0, len(pages))
+	for _, page := range pages {
+		change.Pages = append(change.Pages, OutputURL(OutputRelative(mustRelative(page))))
+	}
+	return change
+}
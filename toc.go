@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"markdown-server/markdown/ast"
+)
+
+/***********************************************
+*** FUNCTIONS FOR HEADING IDS AND THE TOC ***
+************************************************/
+
+// TOCMarker is a paragraph that gets replaced by the table of contents of the page
+const TOCMarker = "[TOC]"
+
+// DefaultTOC places a table of contents in the layout of every page without the front matter key "toc"
+var DefaultTOC = os.Getenv("TOC") != ""
+
+// DefaultTOCLevels are the heading levels listed for pages without the front matter key "toc_levels"
+var DefaultTOCLevels = GetDefaultTOCLevels()
+
+// LevelRange is an inclusive range of heading levels
+type LevelRange struct {
+	Min int
+	Max int
+}
+
+func (levels LevelRange) IsZero() bool {
+	return levels == LevelRange{}
+}
+
+func (levels LevelRange) Contains(level int) bool {
+	return levels.Min <= level && level <= levels.Max
+}
+
+// ParseLevelRange reads "2-4", or a single level "3" meaning every level up to it
+func ParseLevelRange(text string) (LevelRange, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return LevelRange{}, nil
+	}
+	first, last, isRange := strings.Cut(text, "-")
+	if !isRange {
+		first, last = "1", first
+	}
+	minLevel, errMin := strconv.Atoi(strings.TrimSpace(first))
+	maxLevel, errMax := strconv.Atoi(strings.TrimSpace(last))
+	if errMin != nil || errMax != nil || minLevel < 1 || maxLevel > 6 || minLevel > maxLevel {
+		return LevelRange{}, fmt.Errorf("%q is not a range of heading levels like 2-4", text)
+	}
+	return LevelRange{Min: minLevel, Max: maxLevel}, nil
+}
+
+// GetDefaultTOCLevels reads TOC_LEVELS and defaults to the levels 2 to 3
+func GetDefaultTOCLevels() LevelRange {
+	levels, err := ParseLevelRange(os.Getenv("TOC_LEVELS"))
+	if err != nil || levels.IsZero() {
+		return LevelRange{Min: 2, Max: 3}
+	}
+	return levels
+}
+
+func ExpectLevelRange(key string, value any) (LevelRange, error) {
+	text, err := ExpectString(key, value)
+	if err != nil {
+		return LevelRange{}, err
+	}
+	levels, err := ParseLevelRange(text)
+	if err != nil {
+		return LevelRange{}, fmt.Errorf("key %q: %w", key, err)
+	}
+	return levels, nil
+}
+
+// PlainText returns the text of all text and code nodes below the node
+func PlainText(node ast.Node) string {
+	var text strings.Builder
+	ast.WalkFunc(node, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch node.(type) {
+		case *ast.Text, *ast.Code:
+			text.Write(node.AsLeaf().Literal)
+		}
+		return ast.GoToNext
+	})
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// Slugify turns heading text into an id of lower case letters and digits separated by dashes
+func Slugify(text string) string {
+	var slug []rune
+	dash := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			dash = true
+			continue
+		}
+		if dash && len(slug) > 0 {
+			slug = append(slug, '-')
+		}
+		dash = false
+		slug = append(slug, unicode.ToLower(r))
+	}
+	if len(slug) == 0 {
+		return "section"
+	}
+	return string(slug)
+}
+
+// AssignHeadingIDs gives every heading a unique id. Ids written as "{#id}" are kept, all others are
+// generated from the heading text. Repeated ids get "-1", "-2", … appended in document order, so the
+// id of a heading only changes if a heading with the same text is added before it.
+func AssignHeadingIDs(doc ast.Node) {
+	headings := make([]*ast.Heading, 0)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if heading, ok := node.(*ast.Heading); ok && entering {
+			headings = append(headings, heading)
+		}
+		return ast.GoToNext
+	})
+
+	// written ids take precedence over generated ones, wherever they appear
+	taken := make(map[string]bool)
+	written := make(map[*ast.Heading]bool)
+	for _, heading := range headings {
+		if heading.HeadingID != "" && !taken[heading.HeadingID] {
+			taken[heading.HeadingID] = true
+			written[heading] = true
+		}
+	}
+	for _, heading := range headings {
+		if written[heading] {
+			continue
+		}
+		base := heading.HeadingID
+		if base == "" {
+			base = Slugify(PlainText(heading))
+		}
+		id := base
+		for n := 1; taken[id]; n++ {
+			id = base + "-" + strconv.Itoa(n)
+		}
+		heading.HeadingID = id
+		taken[id] = true
+	}
+}
+
+// TOCEntry is a heading in the table of contents
+type TOCEntry struct {
+	ID       string
+	Title    string
+	Children []*TOCEntry
+}
+
+// CollectTOC nests the headings inside the level range. A skipped level does not create an empty entry,
+// the heading becomes a child of the closest heading above it.
+func CollectTOC(doc ast.Node, levels LevelRange) []*TOCEntry {
+	type open struct {
+		level int
+		entry *TOCEntry
+	}
+	roots := make([]*TOCEntry, 0)
+	stack := make([]open, 0)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering || heading.IsTitleblock || !levels.Contains(heading.Level) {
+			return ast.GoToNext
+		}
+		entry := &TOCEntry{ID: heading.HeadingID, Title: PlainText(heading)}
+		for len(stack) != 0 && stack[len(stack)-1].level >= heading.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, entry)
+		} else {
+			parent := stack[len(stack)-1].entry
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, open{level: heading.Level, entry: entry})
+		return ast.SkipChildren
+	})
+	return roots
+}
+
+var TOCTemplate = template.Must(template.New("toc").Parse(`
+{{- define "entries"}}<ul>
+{{- range .}}
+<li><a href="#{{.ID}}">{{.Title}}</a>{{with .Children}}{{template "entries" .}}{{end}}</li>
+{{- end}}
+</ul>{{end}}
+{{- template "entries" .}}`))
+
+func TOCHTML(entries []*TOCEntry) template.HTML {
+	if len(entries) == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	_ = TOCTemplate.Execute(&buffer, entries)
+	return template.HTML(buffer.String())
+}
+
+// ApplyTOC replaces every "[TOC]" paragraph with the table of contents of the page. Without a marker
+// the table of contents is returned for the layout, if the page or its settings enable it.
+func ApplyTOC(doc ast.Node, meta PageMeta, settings *PageConfig) template.HTML {
+	levels := meta.TOCLevels
+	if levels.IsZero() {
+		levels = settings.TOCLevels
+	}
+	toc := TOCHTML(CollectTOC(doc, levels))
+
+	markers := make([]ast.Node, 0)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if paragraph, ok := node.(*ast.Paragraph); ok && entering {
+			if IsTOCMarker(paragraph) {
+				markers = append(markers, paragraph)
+			}
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+	for _, marker := range markers {
+		inline := &ast.HTMLBlock{}
+		if toc != "" {
+			inline.Literal = []byte(`<nav class="toc">` + string(toc) + `</nav>`)
+		}
+		ReplaceNode(marker, inline)
+	}
+
+	enabled := settings.TOC
+	if meta.TOC != nil {
+		enabled = *meta.TOC
+	}
+	if len(markers) != 0 || !enabled {
+		return ""
+	}
+	return toc
+}
+
+func IsTOCMarker(paragraph *ast.Paragraph) bool {
+	children := paragraph.GetChildren()
+	if len(children) != 1 {
+		return false
+	}
+	text, ok := children[0].(*ast.Text)
+	return ok && string(bytes.TrimSpace(text.Literal)) == TOCMarker
+}
+
+// ReplaceNode puts the replacement at the position of the node in its parent
+func ReplaceNode(node ast.Node, replacement ast.Node) {
+	parent := node.GetParent()
+	children := parent.GetChildren()
+	for i, child := range children {
+		if child == node {
+			children[i] = replacement
+		}
+	}
+	replacement.SetParent(parent)
+	parent.SetChildren(children)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"markdown-server/markdown"
+	"markdown-server/markdown/ast"
+	"markdown-server/markdown/parser"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "words", text: "Getting Started", want: "getting-started"},
+		{name: "punctuation", text: "What's new in v1.2?", want: "what-s-new-in-v1-2"},
+		{name: "leading and trailing", text: "  -- Setup! --  ", want: "setup"},
+		{name: "repeated separators", text: "a  /  b", want: "a-b"},
+		{name: "unicode letters", text: "Größe und Übersicht", want: "größe-und-übersicht"},
+		{name: "digits", text: "2024 Roadmap", want: "2024-roadmap"},
+		{name: "no letters", text: "???", want: "section"},
+		{name: "empty", text: "", want: "section"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Slugify(test.text); got != test.want {
+				t.Errorf("Slugify(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAssignHeadingIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     []string
+	}{
+		{name: "generated", markdown: "# Intro\n## Usage\n", want: []string{"intro", "usage"}},
+		{name: "duplicates", markdown: "# Usage\n## Usage\n## Usage\n", want: []string{"usage", "usage-1", "usage-2"}},
+		{name: "written id", markdown: "# Intro {#start}\n", want: []string{"start"}},
+		{
+			name:     "written id wins over an earlier generated one",
+			markdown: "# Usage\n## Other {#usage}\n",
+			want:     []string{"usage-1", "usage"},
+		},
+		{
+			name:     "duplicate written ids",
+			markdown: "# One {#same}\n# Two {#same}\n",
+			want:     []string{"same", "same-1"},
+		},
+		{
+			name:     "generated id taken by a suffix",
+			markdown: "# Usage 1\n# Usage\n# Usage\n",
+			want:     []string{"usage-1", "usage", "usage-2"},
+		},
+		{name: "formatting", markdown: "# The `go` *tool*\n", want: []string{"the-go-tool"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc := markdown.Parse([]byte(test.markdown), parser.NewWithExtensions(Extensions))
+			AssignHeadingIDs(doc)
+			got := make([]string, 0)
+			ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+				if heading, ok := node.(*ast.Heading); ok && entering {
+					got = append(got, heading.HeadingID)
+				}
+				return ast.GoToNext
+			})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("AssignHeadingIDs() ids = %q, want %q", got, test.want)
+			}
+		})
+	}
+}